@@ -5,28 +5,16 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
-
-	"github.com/openai/openai-go/v2"
-	"github.com/openai/openai-go/v2/option"
-)
-
-type secretFile struct {
-	OpenAIKey string `json:"openai_api_key"`
-}
-
-var (
-	secretsOnce sync.Once
-	secretVal   secretFile
-	secretErr   error
 )
 
 // =====================================================
@@ -87,6 +75,10 @@ type AdvisorRequest struct {
 
 	IncludeColleges []string `json:"include_colleges"`
 	ExcludeColleges []string `json:"exclude_colleges"`
+
+	// Supersedes is the id of an earlier job from the same student; it is
+	// cancelled when this request is accepted. Not part of the checksum.
+	Supersedes *string `json:"supersedes,omitempty"`
 }
 
 type errorResponse struct {
@@ -98,15 +90,15 @@ type errorResponse struct {
 // =====================================================
 
 func Advisor(w http.ResponseWriter, r *http.Request) {
-	dbgPrintf("[Advisor] Request received from %s\n", r.RemoteAddr)
+	logDebug("Advisor", "Request received", slog.String("remote_addr", r.RemoteAddr))
 
 	if r.Method == http.MethodOptions {
-		dbgPrintf("[Advisor] OPTIONS request - sending no content\n")
+		logDebug("Advisor", "OPTIONS request - sending no content")
 		writeJSON(w, http.StatusNoContent, map[string]string{"error": "MethodOptions no content"})
 		return
 	}
 	if r.Method != http.MethodPost {
-		dbgPrintf("[Advisor] Invalid method: %s\n", r.Method)
+		logDebug("Advisor", "Invalid method", slog.String("method", r.Method))
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
 		return
 	}
@@ -115,19 +107,21 @@ func Advisor(w http.ResponseWriter, r *http.Request) {
 	// Generate job ID and return immediately with latency snapshot (avg + samples).
 	id, err := genID()
 	if err != nil {
-		errPrintf("[Advisor] Failed to generate ID: %v\n", err)
+		logError("Advisor", "Failed to generate ID", slog.Any("error", err))
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate id"})
 		return
 	}
+	tagJobRequest(id, requestID(r))
+	defer untagOrphanRequest(id)
 
-	dbgPrintf("(ID)[%s] New advisor request received\n", id)
+	logDebug("Advisor", "New advisor request received", slog.String("job_id", id))
 
 	var req AdvisorRequest
 	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20)) // 1MB safety
 	dec.DisallowUnknownFields()
-	dbgPrintf("(ID)[%s] Decoding JSON payload\n", id)
+	logDebug("Advisor", "Decoding JSON payload", slog.String("job_id", id))
 	if err := dec.Decode(&req); err != nil {
-		warnPrintf("(ID)[%s] JSON decode error: %v\n", id, err)
+		logWarn("Advisor", "JSON decode error", slog.String("job_id", id), slog.Any("error", err))
 		writeJSON(w, http.StatusBadRequest, map[string]any{
 			"invalid_fields": map[string]any{
 				"_": BuildJSONErrorDetail(err, r),
@@ -136,22 +130,60 @@ func Advisor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbgPrintf("(ID)[%s] Payload decoded successfully\n", id)
-	dbgPrintf("(ID)[%s] Validating request fields\n", id)
+	logDebug("Advisor", "Payload decoded successfully", slog.String("job_id", id))
+	logDebug("Advisor", "Validating request fields", slog.String("job_id", id))
 	if invalid := validate(req); len(invalid) > 0 {
-		warnPrintf("(ID)[%s] Validation failed: %d invalid field(s)\n", id, len(invalid))
+		logWarn("Advisor", "Validation failed", slog.String("job_id", id), slog.Int("invalid_fields", len(invalid)))
 		writeJSON(w, http.StatusBadRequest, errorResponse{InvalidFields: invalid})
 		return
 	}
-	dbgPrintf("(ID)[%s] Validation passed\n", id)
+	logDebug("Advisor", "Validation passed", slog.String("job_id", id))
+
+	// The job this resubmission replaces is cancelled only once the
+	// replacement is accepted; it is not part of the payload checksum.
+	var supersedes string
+	if req.Supersedes != nil {
+		supersedes = strings.TrimSpace(*req.Supersedes)
+		req.Supersedes = nil
+	}
+
+	member := requestMember(r)
+	limits := limitsFor(member.Plan)
+	if n := schoolAmountOf(req); limits.MaxSchools > 0 && n > limits.MaxSchools {
+		logDebug("Advisor", "Capping school_amount to plan limit",
+			slog.String("job_id", id), slog.Int("requested", n), slog.Int("max", limits.MaxSchools), slog.String("plan", string(member.Plan)))
+		capped := strconv.Itoa(limits.MaxSchools)
+		req.SchoolAmount = &capped
+	}
 
 	// Check cache before processing
-	dbgPrintf("(ID)[%s] Computing payload checksum\n", id)
+	logDebug("Advisor", "Computing payload checksum", slog.String("job_id", id))
 	checksum := checksumPayload(req)
-	dbgPrintf("(ID)[%s] Checksum calculated: %s\n", id, checksum)
-	dbgPrintf("(ID)[%s] Checking cache for existing response\n", id)
-	if cachedResp, found := getCachedResponse(checksum); found {
-		dbgPrintf("(ID)[%s] ✓ Cache HIT - returning cached response immediately\n", id)
+	logDebug("Advisor", "Checksum calculated", slog.String("job_id", id), slog.String("checksum", checksum))
+
+	// Each distinct payload is one run; repeating a payload is free.
+	usage := usageKey(r, member)
+	allowed, newRun, used := planUsage.consume(usage, checksum, limits.MaxRuns, usageRuns)
+	if !allowed {
+		logWarn("Advisor", "Plan run limit reached",
+			slog.String("job_id", id), slog.String("member", usage), slog.Int("used", used), slog.Int("max", limits.MaxRuns), logFail)
+		writePlanLimit(w, member, "runs", limits.MaxRuns, used,
+			"You've used all of your free runs. Upgrade to Pro for unlimited runs and refinements.")
+		return
+	}
+	releaseRun := func() {
+		if newRun {
+			planUsage.release(usage, checksum, usageRuns)
+		}
+	}
+
+	logDebug("Advisor", "Checking cache for existing response", slog.String("job_id", id))
+	lookupStart := time.Now()
+	cachedResp, found := getCachedResponse(checksum)
+	recordCacheLookup(r.Context(), "responses", lookupStart, found)
+	if found {
+		logDebug("Advisor", "Cache HIT - returning cached response immediately", slog.String("job_id", id), logOK)
+		cancelSuperseded(id, supersedes, usage)
 		// Return cached response immediately, skip AI processing
 		writeJSON(w, http.StatusOK, map[string]any{
 			"success": cachedResp,
@@ -159,97 +191,184 @@ func Advisor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbgPrintf("(ID)[%s] ✗ Cache MISS - will process with AI\n", id)
-	dbgPrintf("(ID)[%s] Building prompt from payload\n", id)
-	prompt := buildPrompt(req)
-	dbgPrintf("(ID)[%s] Prompt built (length: %d chars)\n", id, len(prompt))
-	dbgPrintf("(ID)[%s] Retrieving latency statistics\n", id)
+	logDebug("Advisor", "Cache MISS - will process with AI", slog.String("job_id", id), logFail)
+	if isDraining() {
+		logWarn("Advisor", "Not accepting job: shutting down", slog.String("job_id", id), logFail)
+		releaseRun()
+		writeDraining(w)
+		return
+	}
+	if err := llmAvailable(member.Plan); err != nil {
+		logWarn("Advisor", "Not accepting job", slog.String("job_id", id), slog.Any("error", err), logFail)
+		releaseRun()
+		writeLLMUnavailable(w, err)
+		return
+	}
+
+	logDebug("Advisor", "Creating job", slog.String("job_id", id))
+	job := newJob(id, JobKindAdvisor, checksum, req)
+	job.Plan = member.Plan
+	job.Member = usage
+	job.Charged = newRun
+	job.RequestID = requestID(r)
+	job.TraceParent = traceparent(r.Context())
+	if err := jobs.Create(job); err != nil {
+		logError("Advisor", "Failed to create job", slog.String("job_id", id), slog.Any("error", err))
+		releaseRun()
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create job"})
+		return
+	}
+
+	position, err := enqueueJob(job, false)
+	if err != nil {
+		logWarn("Advisor", "Queue full, rejecting job", slog.String("job_id", id), slog.Any("error", err), logFail)
+		jobs.Delete(id)
+		releaseRun()
+		writeQueueFull(w, JobKindAdvisor)
+		return
+	}
+	logDebug("Advisor", "Job queued", slog.String("job_id", id), slog.Int("queue_position", position))
+	cancelSuperseded(id, supersedes, usage)
+
+	logDebug("Advisor", "Retrieving latency statistics", slog.String("job_id", id))
 	count, _, avg := getLatencySnapshot(AdvisorLatency)
-	dbgPrintf("(ID)[%s] Latency stats - samples: %d, avg: %.2fms\n", id, count, avg)
+	logDebug("Advisor", "Latency stats", slog.String("job_id", id), slog.Int64("samples", count), slog.Float64("avg_ms", avg))
+	estimate, window := estimateCompletionMs(JobKindAdvisor, latencySegment{Model: llmModelFor(llmKindAdvisor), Schools: schoolAmountOf(req)}, position)
+	logDebug("Advisor", "Estimated completion",
+		slog.String("job_id", id), slog.Float64("estimate_ms", estimate), slog.Float64("p50_ms", window.P50Ms), slog.Int("samples", window.Samples), slog.String("window", window.Window), slog.String("segment", window.Segment))
 
-	dbgPrintf("(ID)[%s] Sending initial response to client\n", id)
+	logDebug("Advisor", "Sending initial response to client", slog.String("job_id", id))
 	writeJSON(w, http.StatusOK, map[string]any{
 		"id":             id,
 		"avg_chatgpt_ms": avg,   // float64
 		"samples":        count, // int64
+		"estimate_ms":    estimate,
+		"latency":        window,
+		"queue_position": position,
 	})
-	dbgPrintf("(ID)[%s] Response sent, spawning background AI processing\n", id)
+}
+
+// cancelSuperseded cancels the advisor job prev that request id replaces,
+// provided it was submitted by the same caller.
+func cancelSuperseded(id, prev, usage string) {
+	if prev == "" {
+		return
+	}
+	prevJob, ok := jobs.Get(prev)
+	switch {
+	case !ok || prevJob.Kind != JobKindAdvisor:
+		return
+	case prevJob.Member != usage:
+		logWarn("Advisor", "Not cancelling superseded job submitted by another caller",
+			slog.String("job_id", id), slog.String("superseded_id", prev), logFail)
+	case cancelJob(prev):
+		logDebug("Advisor", "Cancelled superseded job", slog.String("job_id", id), slog.String("superseded_id", prev))
+	}
+}
 
-	go Aidvisor_ChatGpt(prompt, id, checksum)
+// schoolAmountOf returns the validated school_amount as an int.
+func schoolAmountOf(req AdvisorRequest) int {
+	if req.SchoolAmount == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(*req.SchoolAmount))
+	return n
 }
 
-func Aidvisor_ChatGpt(prompt string, id string, checksum string) {
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] Background goroutine started\n", id)
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] Saving initial 'Processing' status\n", id)
-	savePrompt(id, "Processing")
+func Aidvisor_ChatGpt(prompt string, id string, checksum string, schoolAmount int) {
+	logDebug("Aidvisor_ChatGpt", "Background goroutine started", slog.String("job_id", id), slog.Int("prompt_chars", len(prompt)))
+	logDebug("Aidvisor_ChatGpt", "Marking job running", slog.String("job_id", id))
+	startJob(id)
 
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] Creating context with 10-minute timeout\n", id)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	logDebug("Aidvisor_ChatGpt", "Creating context with timeout", slog.String("job_id", id), slog.Duration("timeout", advisorJobTimeout))
+	ctx, cancel := jobContext(id, advisorJobTimeout)
 	defer cancel()
 
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] Retrieving OpenAI API key\n", id)
-	key, kerr := getAPIKey()
-	if kerr != nil {
-		errPrintf("[Aidvisor_ChatGpt] (ID)[%s] ✗ Error getting API key: %v\n", id, kerr)
-		savePrompt(id, `{"error":"`+escapeJSON(kerr.Error())+`"}`)
+	logDebug("Aidvisor_ChatGpt", "Resolving LLM provider", slog.String("job_id", id))
+	provider, perr := llmProviderFor(llmKindAdvisor)
+	if perr != nil {
+		logError("Aidvisor_ChatGpt", "Error resolving LLM provider", slog.String("job_id", id), slog.Any("error", perr), logFail)
+		failJob(id, perr.Error(), 0)
+		return
+	}
+	model := llmModelFor(llmKindAdvisor)
+	logDebug("Aidvisor_ChatGpt", "Using provider",
+		slog.String("job_id", id), slog.String("provider", provider.Name()), slog.String("model", model))
+
+	// The budget may have run out while this job sat in the queue.
+	if err := checkBudget(jobPlan(id)); err != nil {
+		logWarn("Aidvisor_ChatGpt", "Budget check failed", slog.String("job_id", id), slog.Any("error", err), logFail)
+		failJob(id, sanitizeOpenAIError(err), 0)
 		return
 	}
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] API key retrieved successfully\n", id)
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] Initializing OpenAI client\n", id)
-	client := openai.NewClient(option.WithAPIKey(key))
 
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] Sending request to OpenAI GPT-5 API...\n", id)
+	logDebug("Aidvisor_ChatGpt", "Sending request to LLM...", slog.String("job_id", id))
 	start := time.Now()
-	resp, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Model: openai.ChatModelGPT5,
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage("You are a helpful college admissions advisor."),
-			openai.UserMessage(prompt),
-		},
-	})
-
-	// Safety deletion in case polling never collects the result.
-	go func() {
-		time.Sleep(time.Minute * 10)
-		if _, check := getPrompt(id); check {
-			dbgPrintf("[Aidvisor_ChatGpt:Cleanup] (ID)[%s] Auto-cleanup: deleting uncollected result\n", id)
-			deletePrompt(id)
+	parse := func(text string) ([]byte, error) {
+		normalized, notes, err := normalizeAdvisorResult(text, schoolAmount)
+		for _, note := range notes {
+			logWarn("Aidvisor_ChatGpt", "Repaired model output", slog.String("job_id", id), slog.String("note", note))
+		}
+		return normalized, err
+	}
+	// Publish schools as their objects complete in the streamed reply. A
+	// retry restarts the stream, so the list may shrink back to empty.
+	published := 0
+	stream := newSchoolStream(schoolAmount)
+	onDelta := func(text string) {
+		schools := stream.feed(text)
+		if len(schools) == published {
+			return
 		}
-	}()
+		published = len(schools)
+		logDebug("Aidvisor_ChatGpt", "Streamed schools so far", slog.String("job_id", id), slog.Int("schools", published))
+		setJobPartial(id, schools)
+	}
+	res, err := completeJSON(ctx, provider, LLMRequest{
+		Kind:    llmKindAdvisor,
+		Key:     checksum,
+		Model:   model,
+		System:  "You are a helpful college admissions advisor.",
+		User:    prompt,
+		Schema:  advisorResultSchema,
+		OnDelta: onDelta,
+	}, parse, AdvisorLatency, id)
 
-	// Record duration for both success and error
 	elapsed := time.Since(start)
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] OpenAI API call completed in %.3fs\n", id, elapsed.Seconds())
-	AdvisorLatency.record(elapsed)
+	logDebug("Aidvisor_ChatGpt", "LLM completed",
+		slog.String("job_id", id), slog.Duration("elapsed", elapsed), slog.Int("attempts", res.Attempts))
+	recordJobUsage(id, res.Model, res.Usage, AdvisorLatency)
 
 	if err != nil {
-		errPrintf("[Aidvisor_ChatGpt] (ID)[%s] ✗ OpenAI API error: %v\n", id, err)
-		userMsg := sanitizeOpenAIError(err)
-		savePrompt(id, `{"error":"`+escapeJSON(userMsg)+`"}`)
-		return
-	}
-
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] ✓ API call successful\n", id)
-	out := resp.Choices[0].Message.Content
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] Response length: %d chars\n", id, len(out))
-
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] Validating JSON response from model\n", id)
-	var js any
-	if jerr := json.Unmarshal([]byte(out), &js); jerr != nil {
-		errPrintf("[Aidvisor_ChatGpt] (ID)[%s] ✗ JSON validation failed: %v\n", id, jerr)
-		savePrompt(id, `{"error":"model did not return valid JSON"}`)
+		switch {
+		case errors.Is(ctx.Err(), context.Canceled):
+			logDebug("Aidvisor_ChatGpt", "Job cancelled, discarding LLM call", slog.String("job_id", id))
+		case errors.Is(err, errNoValidSchools):
+			logError("Aidvisor_ChatGpt", "Contract validation failed", slog.String("job_id", id), slog.Any("error", err), logFail)
+			failJob(id, "model did not return any valid schools", res.Attempts)
+		case errors.Is(err, errLLMInvalidOutput):
+			logError("Aidvisor_ChatGpt", "JSON validation failed", slog.String("job_id", id), slog.Any("error", err), logFail)
+			failJob(id, "model did not return valid JSON", res.Attempts)
+		default:
+			logError("Aidvisor_ChatGpt", "LLM error", slog.String("job_id", id), slog.Any("error", err), logFail)
+			userMsg := sanitizeOpenAIError(err)
+			failJob(id, userMsg, res.Attempts)
+		}
 		return
 	}
+	out := string(res.JSON)
+	AdvisorLatency.observe(elapsed, model, schoolAmount)
 
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] ✓ JSON validation passed\n", id)
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] ChatGPT processing complete (%.3fs)\n", id, elapsed.Seconds())
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] Saving result to prompt store\n", id)
-	savePrompt(id, out)
+	logDebug("Aidvisor_ChatGpt", "Contract validation passed", slog.String("job_id", id), slog.Int("chars", len(out)), logOK)
+	logDebug("Aidvisor_ChatGpt", "ChatGPT processing complete", slog.String("job_id", id), slog.Duration("elapsed", elapsed))
 
-	// Save to cache
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] Saving response to disk cache (checksum: %s)\n", id, checksum)
+	// Save to cache before finishing the job, which drops its job file
+	logDebug("Aidvisor_ChatGpt", "Saving response to disk cache", slog.String("job_id", id), slog.String("checksum", checksum))
 	saveCachedResponse(checksum, out)
-	dbgPrintf("[Aidvisor_ChatGpt] (ID)[%s] ✓ Response cached successfully\n", id)
+	logDebug("Aidvisor_ChatGpt", "Response cached successfully", slog.String("job_id", id), logOK)
+
+	logDebug("Aidvisor_ChatGpt", "Saving result to job store", slog.String("job_id", id))
+	succeedJob(id, res.JSON, res.Attempts)
 }
 
 // =====================================================
@@ -472,7 +591,7 @@ Return your result as STRICT JSON ONLY (no prose). Two possible shapes:
 
 Rules:
 - If key inputs are missing or unclear (e.g., non-numeric GPA, impossible ranges), return ONLY invalid_fields.
-- Otherwise, return ONLY schools with the top %s options, in DESC order by chance, each categorized as Reach/Match/Safety with a short reasoning. standardize the distribution of safety (12.5%) to match (75%) to reach schools (12.5%)
+- Otherwise, return ONLY schools with the top %s options, in DESC order by chance, each categorized as Reach/Match/Safety with a short reasoning. standardize the distribution of safety (12.5%%) to match (75%%) to reach schools (12.5%%)
 - Do not include any text outside of the JSON object.
 `, txt(req.SchoolAmount)))
 	return fmt.Sprintf(
@@ -3,15 +3,15 @@ package handlers
 import (
 	"encoding/json"
 	"io"
+	"log/slog"
 	"net/http"
-	"strings"
 )
 
 func Advisor_Fetch(w http.ResponseWriter, r *http.Request) {
-	dbgPrintf("[Advisor_Fetch] Poll request received from %s\n", r.RemoteAddr)
+	logDebug("Advisor_Fetch", "Poll request received", slog.String("remote_addr", r.RemoteAddr))
 
 	if r.Method != http.MethodPost {
-		dbgPrintf("[Advisor_Fetch] Invalid method: %s\n", r.Method)
+		logDebug("Advisor_Fetch", "Invalid method", slog.String("method", r.Method))
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{
 			"error": "method not allowed",
 		})
@@ -22,9 +22,9 @@ func Advisor_Fetch(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		ID string `json:"id"`
 	}
-	dbgPrintf("[Advisor_Fetch] Decoding request body\n")
+	logDebug("Advisor_Fetch", "Decoding request body")
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
-		warnPrintf("[Advisor_Fetch] JSON decode error: %v\n", err)
+		logWarn("Advisor_Fetch", "JSON decode error", slog.Any("error", err))
 		writeJSON(w, http.StatusOK, map[string]string{
 			"error": "invalid JSON: " + err.Error(),
 		})
@@ -32,38 +32,53 @@ func Advisor_Fetch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if body.ID == "" {
-		dbgPrintf("[Advisor_Fetch] Empty ID received\n")
+		logDebug("Advisor_Fetch", "Empty ID received")
 		writeJSON(w, http.StatusBadRequest, map[string]string{
 			"error": "invalid ID",
 		})
 		return
 	}
 
-	dbgPrintf("[Advisor_Fetch] (ID)[%s] Fetching result from prompt store\n", body.ID)
+	logDebug("Advisor_Fetch", "Fetching job from store", slog.String("job_id", body.ID))
 
-	val, ok := getPrompt(body.ID)
-	if !ok {
-		dbgPrintf("[Advisor_Fetch] (ID)[%s] ✗ ID not found in store\n", body.ID)
+	job, ok := jobs.Get(body.ID)
+	if !ok || job.Kind != JobKindAdvisor {
+		logDebug("Advisor_Fetch", "ID not found in store", slog.String("job_id", body.ID), logFail)
 		writeJSON(w, http.StatusBadRequest, map[string]string{
 			"error": "invalid ID",
 		})
 		return
 	}
+	traceJobPoll(r, job)
 
-	if val == "Processing" {
-		dbgPrintf("[Advisor_Fetch] (ID)[%s] Status: Still processing\n", body.ID)
-	} else if strings.Contains(val, "schools") {
-		dbgPrintf("[Advisor_Fetch] (ID)[%s] ✓ Results ready, sending to client\n", body.ID)
-	} else {
-		dbgPrintf("[Advisor_Fetch] (ID)[%s] Status: %s\n", body.ID, val)
+	// "success" keeps the string contract api.js already parses:
+	// "Processing" while pending, otherwise the result or {"error":...} JSON.
+	var val string
+	switch job.State {
+	case JobSucceeded:
+		logDebug("Advisor_Fetch", "Results ready, sending to client", slog.String("job_id", body.ID), logOK)
+		val = string(job.Result)
+	case JobFailed, JobCancelled:
+		logDebug("Advisor_Fetch", "Job ended without a result",
+			slog.String("job_id", body.ID), slog.String("state", string(job.State)), slog.String("job_error", job.Error))
+		b, _ := json.Marshal(map[string]string{"error": job.Error})
+		val = string(b)
+	default:
+		logDebug("Advisor_Fetch", "Still processing", slog.String("job_id", body.ID), slog.String("state", string(job.State)))
+		val = "Processing"
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{
+	resp := map[string]any{
 		"success": val,
-	})
-
-	if strings.Contains(val, "schools") {
-		dbgPrintf("[Advisor_Fetch] (ID)[%s] Final result delivered, cleaning up store\n", body.ID)
-		deletePrompt(body.ID)
+		"state":   job.State,
+	}
+	if pos := jobQueuePosition(job); pos > 0 {
+		resp["queue_position"] = pos
+	}
+	// Not "schools": api.js treats a top-level schools[] as the final result.
+	if len(job.Partial) > 0 && !job.State.Final() {
+		resp["partial"] = true
+		resp["schools_so_far"] = job.Partial
 	}
+	writeJSON(w, http.StatusOK, resp)
 }
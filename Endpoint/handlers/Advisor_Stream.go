@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// sseHeartbeat keeps idle connections open through proxies.
+const sseHeartbeat = 15 * time.Second
+
+// GET /CollegeStream?id=<id>
+// Streams the advisor job as Server-Sent Events until it finishes:
+//
+//	event: state   data: {"state":"queued","queue_position":N}
+//	event: state   data: {"state":"running"}
+//	event: schools data: {"schools":[...],"partial":true}   (schools so far)
+//	event: done    data: {"schools":[...]}
+//	event: error   data: {"error":"..."}
+//
+// The stream closes after done/error, which delivers the result the same way
+// /CollegeFetch does.
+func Advisor_Stream(w http.ResponseWriter, r *http.Request) {
+	logDebug("Advisor_Stream", "Stream request received", slog.String("remote_addr", r.RemoteAddr))
+
+	if r.Method != http.MethodGet {
+		logDebug("Advisor_Stream", "Invalid method", slog.String("method", r.Method))
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	job, ok := jobs.Get(id)
+	if id == "" || !ok || job.Kind != JobKindAdvisor {
+		logDebug("Advisor_Stream", "ID not found in store", slog.String("job_id", id), logFail)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid ID"})
+		return
+	}
+	traceJobPoll(r, job)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logError("Advisor_Stream", "ResponseWriter does not support flushing", slog.String("job_id", id))
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming unsupported"})
+		return
+	}
+
+	// Subscribe before the first read so no change is missed in between.
+	changed, stop := watchJob(id)
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable nginx response buffering
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	logDebug("Advisor_Stream", "Stream opened", slog.String("job_id", id))
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	var lastState JobState
+	lastPosition, lastPartial := -1, 0
+	for {
+		job, ok = jobs.Get(id)
+		if !ok {
+			logDebug("Advisor_Stream", "Job no longer in store, closing stream", slog.String("job_id", id))
+			writeSSE(w, flusher, "error", map[string]string{"error": "invalid ID"})
+			return
+		}
+
+		switch job.State {
+		case JobSucceeded:
+			logDebug("Advisor_Stream", "Results ready, sending to client", slog.String("job_id", id), logOK)
+			writeSSE(w, flusher, "done", job.Result)
+			return
+		case JobFailed, JobCancelled:
+			logDebug("Advisor_Stream", "Job ended without a result",
+				slog.String("job_id", id), slog.String("state", string(job.State)), slog.String("job_error", job.Error))
+			writeSSE(w, flusher, "error", map[string]string{"error": job.Error})
+			return
+		}
+
+		position := jobQueuePosition(job)
+		if job.State != lastState || position != lastPosition {
+			ev := map[string]any{"state": job.State}
+			if position > 0 {
+				ev["queue_position"] = position
+			}
+			if err := writeSSE(w, flusher, "state", ev); err != nil {
+				logDebug("Advisor_Stream", "Client went away", slog.String("job_id", id), slog.Any("error", err))
+				return
+			}
+			lastState, lastPosition = job.State, position
+		}
+
+		if len(job.Partial) != lastPartial {
+			if err := writeSSE(w, flusher, "schools", map[string]any{"schools": job.Partial, "partial": true}); err != nil {
+				logDebug("Advisor_Stream", "Client went away", slog.String("job_id", id), slog.Any("error", err))
+				return
+			}
+			lastPartial = len(job.Partial)
+		}
+
+		select {
+		case <-r.Context().Done():
+			logDebug("Advisor_Stream", "Client disconnected", slog.String("job_id", id))
+			return
+		case <-changed:
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSE writes one event with data as single-line JSON.
+func writeSSE(w http.ResponseWriter, flusher http.Flusher, event string, data any) error {
+	b, err := json.Marshal(data) // also compacts json.RawMessage, which may be indented on disk
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type sseEvent struct {
+	Name string
+	Data string
+}
+
+// openStream opens /CollegeStream for id and checks the SSE headers.
+func openStream(t *testing.T, srv *httptest.Server, id string) *bufio.Reader {
+	t.Helper()
+	resp, err := srv.Client().Get(srv.URL + "/CollegeStream?id=" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream %s: status %d, want 200", id, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("stream %s: Content-Type %q, want text/event-stream", id, ct)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// nextEvent reads one event, skipping heartbeat comments. It returns io.EOF
+// once the server has closed the stream.
+func nextEvent(r *bufio.Reader) (sseEvent, error) {
+	var ev sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && ev.Name != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return ev, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if ev.Name != "" {
+				return ev, nil
+			}
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "event: "):
+			ev.Name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.Data = strings.TrimPrefix(line, "data: ")
+		default:
+			return ev, errors.New("unexpected line " + line)
+		}
+	}
+}
+
+// mustEvent reads the next event and decodes its data into out.
+func mustEvent(t *testing.T, r *bufio.Reader, out any) string {
+	t.Helper()
+	ev, err := nextEvent(r)
+	if err != nil {
+		t.Fatalf("reading event: %v", err)
+	}
+	if err := json.Unmarshal([]byte(ev.Data), out); err != nil {
+		t.Fatalf("%s event data %q: %v", ev.Name, ev.Data, err)
+	}
+	return ev.Name
+}
+
+func TestAdvisorStreamDone(t *testing.T) {
+	srv := newTestServer(t)
+	status, reply := submitAdvisor(t, srv, "3.1", "")
+	id, _ := reply["id"].(string)
+	if status != http.StatusOK || id == "" {
+		t.Fatalf("submit: status %d, %v", status, reply)
+	}
+
+	r := openStream(t, srv, id)
+	var names []string
+	for {
+		var data struct {
+			State   JobState         `json:"state"`
+			Schools []map[string]any `json:"schools"`
+			Partial bool             `json:"partial"`
+		}
+		name := mustEvent(t, r, &data)
+		names = append(names, name)
+		switch name {
+		case "state":
+			if data.State != JobQueued && data.State != JobRunning {
+				t.Errorf("state event %q, want queued or running", data.State)
+			}
+			continue
+		case "schools":
+			if !data.Partial {
+				t.Error("schools event is not marked partial")
+			}
+			continue
+		case "done":
+			if len(data.Schools) == 0 || data.Partial {
+				t.Errorf("done event has %d school(s), partial %v", len(data.Schools), data.Partial)
+			}
+		default:
+			t.Fatalf("unexpected %s event after %v", name, names)
+		}
+		break
+	}
+	if ev, err := nextEvent(r); err != io.EOF {
+		t.Errorf("stream still open after done: %+v, %v", ev, err)
+	}
+}
+
+func TestAdvisorStreamCancelled(t *testing.T) {
+	srv, started := newBlockingServer(t)
+	_, reply := submitAdvisor(t, srv, "3.1", "")
+	id, _ := reply["id"].(string)
+	waitStarted(t, started)
+
+	r := openStream(t, srv, id)
+	var state struct {
+		State         JobState `json:"state"`
+		QueuePosition int      `json:"queue_position"`
+	}
+	if name := mustEvent(t, r, &state); name != "state" || state.State != JobRunning || state.QueuePosition != 0 {
+		t.Fatalf("first event %s %+v, want state running", name, state)
+	}
+
+	cancelJob(id)
+	var failed struct {
+		Error string `json:"error"`
+	}
+	if name := mustEvent(t, r, &failed); name != "error" || !strings.Contains(failed.Error, cancelledJobMessage) {
+		t.Errorf("after cancel: %s event %+v, want error with the cancel message", name, failed)
+	}
+	if ev, err := nextEvent(r); err != io.EOF {
+		t.Errorf("stream still open after error: %+v, %v", ev, err)
+	}
+}
+
+func TestAdvisorStreamUnknownJob(t *testing.T) {
+	srv := newTestServer(t)
+	resp, err := srv.Client().Get(srv.URL + "/CollegeStream?id=no-such-job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown job: status %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// POST /CollegeCancel  {"id":"<id>"}
+// Cancels a queued or running advisor or details job submitted by the same
+// caller (member token, or client IP when anonymous). Returns
+//
+//	{ "cancelled": true,  "state":"cancelled" }
+//	{ "cancelled": false, "state":"succeeded|failed|cancelled" }   (already finished)
+//
+// Pollers then see the job as cancelled on /CollegeFetch,
+// /CollegeAdvisorDetailsStatus and /CollegeStream.
+func CancelJob(w http.ResponseWriter, r *http.Request) {
+	logDebug("CancelJob", "Cancel request received", slog.String("remote_addr", r.RemoteAddr))
+
+	if r.Method != http.MethodPost {
+		logDebug("CancelJob", "Invalid method", slog.String("method", r.Method))
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	defer r.Body.Close()
+
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&body); err != nil && err != io.EOF {
+		logWarn("CancelJob", "JSON decode error", slog.Any("error", err))
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+
+	job, ok := jobs.Get(body.ID)
+	if body.ID == "" || !ok {
+		logDebug("CancelJob", "ID not found in store", slog.String("job_id", body.ID), logFail)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid ID"})
+		return
+	}
+	// Only the submitter may cancel; anyone else is told the ID is unknown.
+	if caller := usageKey(r, requestMember(r)); job.Member != caller {
+		logWarn("CancelJob", "Refused to cancel another caller's job",
+			slog.String("job_id", body.ID), slog.String("caller", caller), slog.String("owner", job.Member), logFail)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid ID"})
+		return
+	}
+
+	cancelled := cancelJob(body.ID)
+	job, _ = jobs.Get(body.ID)
+	traceJobPoll(r, job)
+	if cancelled {
+		logDebug("CancelJob", "Job cancelled", slog.String("job_id", body.ID), logOK)
+	} else {
+		logDebug("CancelJob", "Job already finished, nothing to cancel",
+			slog.String("job_id", body.ID), slog.String("state", string(job.State)))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"cancelled": cancelled,
+		"state":     job.State,
+	})
+}
@@ -7,15 +7,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
-
-	"github.com/openai/openai-go/v2"
-	"github.com/openai/openai-go/v2/option"
 )
 
 // =====================================================
@@ -28,10 +26,10 @@ type SchoolDetailsRequest struct {
 }
 
 // ---- Cache config ----
-const (
-	cacheDirName = "college_details_cache" // relative to process working dir
-	cacheTTL     = 7 * 24 * time.Hour      // one week
-)
+
+// detailsCache holds one JSON file per school slug, relative to the process
+// working dir. Set from the config at startup.
+var detailsCache = cacheLocation{Dir: "college_details_cache", TTL: 7 * 24 * time.Hour}
 
 var slugifyRegex = regexp.MustCompile(`[^a-z0-9]+`)
 
@@ -47,11 +45,11 @@ func slugify(s string) string {
 
 func cachePathForSchool(school string) string {
 	slug := slugify(school)
-	return filepath.Join(cacheDirName, slug+".json")
+	return filepath.Join(detailsCache.Dir, slug+".json")
 }
 
 func ensureCacheDir() error {
-	return os.MkdirAll(cacheDirName, 0o755)
+	return os.MkdirAll(detailsCache.Dir, 0o755)
 }
 
 func readFreshCache(path string) ([]byte, bool, error) {
@@ -62,7 +60,7 @@ func readFreshCache(path string) ([]byte, bool, error) {
 		}
 		return nil, false, err
 	}
-	if time.Since(fi.ModTime()) > cacheTTL {
+	if time.Since(fi.ModTime()) > detailsCache.TTL {
 		return nil, false, nil
 	}
 	b, err := os.ReadFile(path)
@@ -86,36 +84,32 @@ func writeCache(path string, content []byte) error {
 	if err := json.Unmarshal(content, &js); err != nil {
 		return fmt.Errorf("refusing to cache invalid JSON: %w", err)
 	}
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, content, 0o644); err != nil {
-		return err
-	}
-	return os.Rename(tmp, path)
+	return writeFileAtomic(path, content, 0o644)
 }
 
 // POST /CollegeAdvisorDetails
 func SchoolDetails(w http.ResponseWriter, r *http.Request) {
-	dbgPrintf("[SchoolDetails] Request received from %s\n", r.RemoteAddr)
+	logDebug("SchoolDetails", "Request received", slog.String("remote_addr", r.RemoteAddr))
 
 	if r.Method == http.MethodOptions {
-		dbgPrintf("[SchoolDetails] OPTIONS request - sending no content\n")
+		logDebug("SchoolDetails", "OPTIONS request - sending no content")
 		writeJSON(w, http.StatusNoContent, map[string]string{"error": "MethodOptions no content"})
 		return
 	}
 	if r.Method != http.MethodPost {
-		dbgPrintf("[SchoolDetails] Invalid method: %s\n", r.Method)
+		logDebug("SchoolDetails", "Invalid method", slog.String("method", r.Method))
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
 		return
 	}
 	defer r.Body.Close()
 
-	dbgPrintf("[SchoolDetails] Decoding request payload\n")
+	logDebug("SchoolDetails", "Decoding request payload")
 
 	var req SchoolDetailsRequest
 	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(&req); err != nil {
-		warnPrintf("[SchoolDetails] JSON decode error: %v\n", err)
+		logWarn("SchoolDetails", "JSON decode error", slog.Any("error", err))
 		writeJSON(w, http.StatusBadRequest, map[string]any{
 			"error":          "invalid json",
 			"invalid_fields": map[string]any{"_": BuildJSONErrorDetail(err, r)},
@@ -124,83 +118,151 @@ func SchoolDetails(w http.ResponseWriter, r *http.Request) {
 	}
 	school := strings.TrimSpace(req.School)
 	if school == "" {
-		dbgPrintf("[SchoolDetails] Missing school name\n")
+		logDebug("SchoolDetails", "Missing school name")
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing 'school' name"})
 		return
 	}
 
-	dbgPrintf("(School)[%s] Request decoded successfully\n", school)
+	logDebug("SchoolDetails", "Request decoded successfully", slog.String("school", school))
+
+	// Details are charged per distinct school, cached or not.
+	member := requestMember(r)
+	limits := limitsFor(member.Plan)
+	usage := usageKey(r, member)
+	slug := slugify(school)
+	allowed, newLookup, used := planUsage.consume(usage, slug, limits.MaxDetails, usageDetails)
+	if !allowed {
+		logWarn("SchoolDetails", "Plan detail lookup limit reached",
+			slog.String("school", school), slog.String("member", usage), slog.Int("used", used), slog.Int("max", limits.MaxDetails), logFail)
+		writePlanLimit(w, member, "details", limits.MaxDetails, used,
+			"School details are a Pro feature. Upgrade to Pro to see full school details.")
+		return
+	}
+	releaseLookup := func() {
+		if newLookup {
+			planUsage.release(usage, slug, usageDetails)
+		}
+	}
 
 	// Try cache first
 	cachePath := cachePathForSchool(school)
-	dbgPrintf("(School)[%s] Checking cache at: %s\n", school, cachePath)
-	if cached, ok, err := readFreshCache(cachePath); err == nil && ok {
-		dbgPrintf("(School)[%s] ✓ Cache HIT - returning cached details\n", school)
+	logDebug("SchoolDetails", "Checking cache", slog.String("school", school), slog.String("path", cachePath))
+	lookupStart := time.Now()
+	cached, ok, err := readFreshCache(cachePath)
+	recordCacheLookup(r.Context(), "details", lookupStart, err == nil && ok)
+	if err == nil && ok {
+		logDebug("SchoolDetails", "Cache HIT - returning cached details", slog.String("school", school), logOK)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write(cached)
 		return
 	} else if err != nil {
-		warnPrintf("(School)[%s] ✗ Cache read error: %v\n", school, err)
+		logWarn("SchoolDetails", "Cache read error", slog.String("school", school), slog.Any("error", err), logFail)
 	} else {
-		dbgPrintf("(School)[%s] ✗ Cache MISS - will generate details\n", school)
+		logDebug("SchoolDetails", "Cache MISS - will generate details", slog.String("school", school), logFail)
+	}
+
+	if isDraining() {
+		logWarn("SchoolDetails", "Not accepting job: shutting down", slog.String("school", school), logFail)
+		releaseLookup()
+		writeDraining(w)
+		return
+	}
+	if err := llmAvailable(member.Plan); err != nil {
+		logWarn("SchoolDetails", "Not accepting job", slog.String("school", school), slog.Any("error", err), logFail)
+		releaseLookup()
+		writeLLMUnavailable(w, err)
+		return
 	}
 
 	// No fresh cache -> async path
-	dbgPrintf("(School)[%s] Generating job ID\n", school)
+	logDebug("SchoolDetails", "Generating job ID", slog.String("school", school))
 	id, err := genID()
 	if err != nil {
-		errPrintf("(School)[%s] Failed to generate ID: %v\n", school, err)
+		logError("SchoolDetails", "Failed to generate ID", slog.String("school", school), slog.Any("error", err))
+		releaseLookup()
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate id"})
 		return
 	}
-
-	dbgPrintf("(ID)[%s] (School)[%s] Job created\n", id, school)
+	tagJobRequest(id, requestID(r))
+	defer untagOrphanRequest(id)
+
+	job := newJob(id, JobKindDetails, slug, req)
+	job.Plan = member.Plan
+	job.Member = usage
+	job.Charged = newLookup
+	job.RequestID = requestID(r)
+	job.TraceParent = traceparent(r.Context())
+	if err := jobs.Create(job); err != nil {
+		logError("SchoolDetails", "Failed to create job", slog.String("job_id", id), slog.String("school", school), slog.Any("error", err))
+		releaseLookup()
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create job"})
+		return
+	}
+	position, err := enqueueJob(job, false)
+	if err != nil {
+		logWarn("SchoolDetails", "Queue full, rejecting job", slog.String("job_id", id), slog.Any("error", err), logFail)
+		jobs.Delete(id)
+		releaseLookup()
+		writeQueueFull(w, JobKindDetails)
+		return
+	}
+	logDebug("SchoolDetails", "Job queued", slog.String("job_id", id), slog.String("school", school), slog.Int("queue_position", position))
 
 	// Use DetailsLatency stats for a time sample the UI can use for a progress bar
-	dbgPrintf("(ID)[%s] Retrieving latency statistics\n", id)
+	logDebug("SchoolDetails", "Retrieving latency statistics", slog.String("job_id", id))
 	count, _, avg := getLatencySnapshot(DetailsLatency)
-	dbgPrintf("(ID)[%s] Latency stats - samples: %d, avg: %.2fms\n", id, count, avg)
+	logDebug("SchoolDetails", "Latency stats", slog.String("job_id", id), slog.Int64("samples", count), slog.Float64("avg_ms", avg))
+	estimate, window := estimateCompletionMs(JobKindDetails, latencySegment{Model: llmModelFor(llmKindDetails)}, position)
+	logDebug("SchoolDetails", "Estimated completion",
+		slog.String("job_id", id), slog.Float64("estimate_ms", estimate), slog.Float64("p50_ms", window.P50Ms), slog.Int("samples", window.Samples), slog.String("window", window.Window), slog.String("segment", window.Segment))
 
 	// Initial response to browser w/ time sample (ms) and sample count
-	dbgPrintf("(ID)[%s] Sending initial response to client\n", id)
+	logDebug("SchoolDetails", "Sending initial response to client", slog.String("job_id", id))
 	writeJSON(w, http.StatusOK, map[string]any{
 		"id":             id,
 		"avg_chatgpt_ms": avg,   // float64
 		"samples":        count, // int64
+		"estimate_ms":    estimate,
+		"latency":        window,
+		"queue_position": position,
 	})
-	dbgPrintf("(ID)[%s] Response sent, spawning background processing\n", id)
-
-	// Kick off background generation
-	go SchoolDetails_ChatGpt(req, school, cachePath, id)
 }
 
 func SchoolDetails_ChatGpt(req SchoolDetailsRequest, school string, cachePath string, id string) {
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] Background goroutine started for: %s\n", id, school)
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] Saving initial 'Processing' status\n", id)
-	savePrompt(id, "Processing")
+	logDebug("SchoolDetails_ChatGpt", "Background goroutine started", slog.String("job_id", id), slog.String("school", school))
+	logDebug("SchoolDetails_ChatGpt", "Marking job running", slog.String("job_id", id))
+	startJob(id)
 
 	// Compact profile summary for the prompt
 	var profileJSON string
 	if req.Profile != nil {
 		if b, err := json.MarshalIndent(req.Profile, "", "  "); err == nil {
 			profileJSON = string(b)
-			dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] Student profile included (%d chars)\n", id, len(profileJSON))
+			logDebug("SchoolDetails_ChatGpt", "Student profile included",
+				slog.String("job_id", id), slog.Int("profile_chars", len(profileJSON)))
 		}
 	} else {
-		dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] No student profile provided\n", id)
+		logDebug("SchoolDetails_ChatGpt", "No student profile provided", slog.String("job_id", id))
 	}
 
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] Retrieving OpenAI API key\n", id)
-	key, kerr := getAPIKey()
-	if kerr != nil {
-		errPrintf("[SchoolDetails_ChatGpt] (ID)[%s] ✗ Error getting API key: %v\n", id, kerr)
-		savePrompt(id, `{"error":"`+escapeJSON(kerr.Error())+`"}`)
+	logDebug("SchoolDetails_ChatGpt", "Resolving LLM provider", slog.String("job_id", id))
+	provider, perr := llmProviderFor(llmKindDetails)
+	if perr != nil {
+		logError("SchoolDetails_ChatGpt", "Error resolving LLM provider", slog.String("job_id", id), slog.Any("error", perr), logFail)
+		failJob(id, perr.Error(), 0)
+		return
+	}
+	model := llmModelFor(llmKindDetails)
+	logDebug("SchoolDetails_ChatGpt", "Using provider",
+		slog.String("job_id", id), slog.String("provider", provider.Name()), slog.String("model", model))
+
+	// The budget may have run out while this job sat in the queue.
+	if err := checkBudget(jobPlan(id)); err != nil {
+		logWarn("SchoolDetails_ChatGpt", "Budget check failed", slog.String("job_id", id), slog.Any("error", err), logFail)
+		failJob(id, sanitizeOpenAIError(err), 0)
 		return
 	}
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] API key retrieved successfully\n", id)
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] Initializing OpenAI client\n", id)
-	client := openai.NewClient(option.WithAPIKey(key))
 
 	// Prompt: ask for STRICT JSON with fields your JS expects.
 	system := "You are a precise, fact-conscious college admissions advisor. Return ONLY strict JSON—no extra text."
@@ -252,108 +314,120 @@ Guidelines:
 - Output ONLY the JSON object.
 `, school, profileJSON)
 
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] Creating context with 3-minute timeout\n", id)
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	logDebug("SchoolDetails_ChatGpt", "Creating context with timeout",
+		slog.String("job_id", id), slog.Duration("timeout", detailsJobTimeout))
+	ctx, cancel := jobContext(id, detailsJobTimeout)
 	defer cancel()
 
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] Sending request to OpenAI GPT-5 API...\n", id)
+	logDebug("SchoolDetails_ChatGpt", "Sending request to LLM...", slog.String("job_id", id))
 	start := time.Now()
-	resp, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Model: openai.ChatModelGPT5,
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(system),
-			openai.UserMessage(user),
-		},
-	})
+	res, err := completeJSON(ctx, provider, LLMRequest{
+		Kind:   llmKindDetails,
+		Key:    slugify(school),
+		Model:  model,
+		System: system,
+		User:   user,
+	}, parseDetailsJSON, DetailsLatency, id)
 
-	// Record latency like Advisor()
 	elapsed := time.Since(start)
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] OpenAI API call completed in %.3fs\n", id, elapsed.Seconds())
-	DetailsLatency.record(elapsed)
+	logDebug("SchoolDetails_ChatGpt", "LLM completed",
+		slog.String("job_id", id), slog.Duration("elapsed", elapsed), slog.Int("attempts", res.Attempts))
+	recordJobUsage(id, res.Model, res.Usage, DetailsLatency)
 
 	if err != nil {
-		errPrintf("[SchoolDetails_ChatGpt] (ID)[%s] ✗ OpenAI API error: %v\n", id, err)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			logDebug("SchoolDetails_ChatGpt", "Job cancelled, discarding LLM call", slog.String("job_id", id))
+			return
+		}
+		if errors.Is(err, errLLMInvalidOutput) {
+			logError("SchoolDetails_ChatGpt", "JSON validation failed", slog.String("job_id", id), slog.Any("error", err), logFail)
+			failJob(id, "model did not return valid JSON", res.Attempts)
+			return
+		}
+		logError("SchoolDetails_ChatGpt", "LLM error", slog.String("job_id", id), slog.Any("error", err), logFail)
 		userMsg := sanitizeOpenAIError(err)
-		savePrompt(id, `{"error":"`+escapeJSON(userMsg)+`"}`)
-		return
-	}
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] ✓ API call successful\n", id)
-	out := resp.Choices[0].Message.Content
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] Response length: %d chars\n", id, len(out))
-
-	// Enforce strict JSON before sending to the client.
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] Validating JSON response from model\n", id)
-	var js any
-	if jerr := json.Unmarshal([]byte(out), &js); jerr != nil {
-		errPrintf("[SchoolDetails_ChatGpt] (ID)[%s] ✗ JSON validation failed: %v\n", id, jerr)
-		savePrompt(id, `{"error":"model did not return valid JSON"}`)
+		failJob(id, userMsg, res.Attempts)
 		return
 	}
+	out := string(res.JSON)
+	DetailsLatency.observe(elapsed, model, 0)
 
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] ✓ JSON validation passed\n", id)
+	logDebug("SchoolDetails_ChatGpt", "JSON validation passed", slog.String("job_id", id), slog.Int("chars", len(out)), logOK)
 
 	// Cache the valid JSON (best-effort)
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] Saving details to cache: %s\n", id, cachePath)
+	logDebug("SchoolDetails_ChatGpt", "Saving details to cache", slog.String("job_id", id), slog.String("path", cachePath))
 	if err := writeCache(cachePath, []byte(out)); err != nil {
-		warnPrintf("(School)[%s] ✗ Cache write error: %v\n", school, err)
+		logWarn("SchoolDetails_ChatGpt", "Cache write error", slog.String("school", school), slog.Any("error", err), logFail)
 	} else {
-		dbgPrintf("(School)[%s] ✓ Details cached successfully\n", school)
+		logDebug("SchoolDetails_ChatGpt", "Details cached successfully", slog.String("school", school), logOK)
 	}
 
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] (School)[%s] ChatGPT processing complete (%.3fs)\n", id, school, elapsed.Seconds())
-	dbgPrintf("[SchoolDetails_ChatGpt] (ID)[%s] Saving result to prompt store\n", id)
-	savePrompt(id, out)
+	logDebug("SchoolDetails_ChatGpt", "ChatGPT processing complete",
+		slog.String("job_id", id), slog.String("school", school), slog.Duration("elapsed", elapsed))
+	logDebug("SchoolDetails_ChatGpt", "Saving result to job store", slog.String("job_id", id))
+	succeedJob(id, res.JSON, res.Attempts)
+}
+
+// parseDetailsJSON accepts any JSON object; the details shape is advisory.
+func parseDetailsJSON(text string) ([]byte, error) {
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(text), &obj); err != nil {
+		return nil, err
+	}
+	return []byte(text), nil
 }
 
 // GET /CollegeAdvisorDetailsStatus?id=<id>
 // Returns either:
 //
-//	{ "status":"processing" }
+//	{ "status":"processing", "state":"queued|running", "queue_position":N }
 //	{ "status":"done", "data": <STRICT JSON from model> }
 //	{ "status":"error", "message":"..." }
+//	{ "status":"cancelled", "message":"..." }
 func SchoolDetailsStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
-		dbgPrintf("[SchoolDetailsStatus] OPTIONS request - sending no content\n")
+		logDebug("SchoolDetailsStatus", "OPTIONS request - sending no content")
 		writeJSON(w, http.StatusNoContent, map[string]string{"error": "MethodOptions no content"})
 		return
 	}
 	if r.Method != http.MethodGet {
-		dbgPrintf("[SchoolDetailsStatus] Invalid method: %s\n", r.Method)
+		logDebug("SchoolDetailsStatus", "Invalid method", slog.String("method", r.Method))
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
 		return
 	}
 
 	id := strings.TrimSpace(r.URL.Query().Get("id"))
 	if id == "" {
-		dbgPrintf("[SchoolDetailsStatus] Missing ID parameter\n")
+		logDebug("SchoolDetailsStatus", "Missing ID parameter")
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing id"})
 		return
 	}
 
-	dbgPrintf("[SchoolDetailsStatus] (ID)[%s] Status check requested\n", id)
-	val, ok := getPrompt(id)
-	if !ok {
-		dbgPrintf("[SchoolDetailsStatus] (ID)[%s] ✗ ID not found in store\n", id)
+	logDebug("SchoolDetailsStatus", "Status check requested", slog.String("job_id", id))
+	job, ok := jobs.Get(id)
+	if !ok || job.Kind != JobKindDetails {
+		logDebug("SchoolDetailsStatus", "ID not found in store", slog.String("job_id", id), logFail)
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
 		return
 	}
-	if val == "Processing" {
-		dbgPrintf("[SchoolDetailsStatus] (ID)[%s] Status: Still processing\n", id)
-		writeJSON(w, http.StatusOK, map[string]any{"status": "processing"})
-		return
-	}
-
-	// Try parse as JSON output; if it fails, treat as error string JSON we saved above.
-	var parsed any
-	if err := json.Unmarshal([]byte(val), &parsed); err == nil {
-		dbgPrintf("[SchoolDetailsStatus] (ID)[%s] ✓ Details complete, delivering to client\n", id)
-		// Optional: delete once delivered to keep memory clean
-		deletePrompt(id)
-		writeJSON(w, http.StatusOK, map[string]any{"status": "done", "data": parsed})
-		return
+	traceJobPoll(r, job)
+
+	switch job.State {
+	case JobSucceeded:
+		logDebug("SchoolDetailsStatus", "Details complete, delivering to client", slog.String("job_id", id), logOK)
+		writeJSON(w, http.StatusOK, map[string]any{"status": "done", "data": job.Result})
+	case JobFailed:
+		logWarn("SchoolDetailsStatus", "Job failed", slog.String("job_id", id), slog.String("job_error", job.Error))
+		writeJSON(w, http.StatusOK, map[string]any{"status": "error", "message": job.Error})
+	case JobCancelled:
+		logDebug("SchoolDetailsStatus", "Status: cancelled", slog.String("job_id", id))
+		writeJSON(w, http.StatusOK, map[string]any{"status": "cancelled", "message": job.Error})
+	default:
+		logDebug("SchoolDetailsStatus", "Still processing", slog.String("job_id", id), slog.String("state", string(job.State)))
+		resp := map[string]any{"status": "processing", "state": job.State}
+		if pos := jobQueuePosition(job); pos > 0 {
+			resp["queue_position"] = pos
+		}
+		writeJSON(w, http.StatusOK, resp)
 	}
-
-	// If it's not valid JSON, return as error
-	warnPrintf("[SchoolDetailsStatus] (ID)[%s] Error status: %s\n", id, val)
-	writeJSON(w, http.StatusOK, map[string]any{"status": "error", "message": val})
 }
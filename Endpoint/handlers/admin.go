@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =====================================================
+//                      Admin API
+// =====================================================
+//
+// Operator endpoints under /admin. They are separate from member auth and
+// only exist when a token is configured:
+//
+//	ADMIN_TOKEN   shared secret sent as "Authorization: Bearer <token>"
+
+// WithAdmin wraps an admin handler. Without ADMIN_TOKEN the admin API is
+// disabled and answers 404.
+func WithAdmin(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.TrimSpace(secretsConfig.AdminToken) == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !isAdmin(r) {
+			logWarn("WithAdmin", "Rejected admin request", slog.String("path", r.URL.Path), slog.String("client_ip", clientIP(r)), logFail)
+			writeAuthError(w, http.StatusUnauthorized, "admin_required", "Admin token required.")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// isAdmin reports whether r carries the admin token.
+func isAdmin(r *http.Request) bool {
+	want := strings.TrimSpace(secretsConfig.AdminToken)
+	token, ok := bearerToken(r)
+	return want != "" && ok && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// ---- Jobs ----
+
+// adminJob is a job without its input and result, which can hold student
+// profiles and large payloads.
+type adminJob struct {
+	ID            string    `json:"id"`
+	Kind          JobKind   `json:"kind"`
+	State         JobState  `json:"state"`
+	Key           string    `json:"key,omitempty"`
+	Plan          Plan      `json:"plan,omitempty"`
+	Member        string    `json:"member,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	StartedAt     time.Time `json:"started_at,omitzero"`
+	FinishedAt    time.Time `json:"finished_at,omitzero"`
+	Attempts      int       `json:"attempts"`
+	QueuePosition int       `json:"queue_position,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Model         string    `json:"model,omitempty"`
+	Usage         LLMUsage  `json:"usage,omitzero"`
+	CostUSD       float64   `json:"cost_usd,omitempty"`
+}
+
+// Admin_Jobs lists jobs in the job store, newest first.
+//
+//	GET /admin/jobs?state=running&kind=advisor&limit=100
+func Admin_Jobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query()
+	state, kind := JobState(q.Get("state")), JobKind(q.Get("kind"))
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "'limit' must be a positive number"})
+			return
+		}
+		limit = n
+	}
+
+	all := jobs.List()
+	sort.Slice(all, func(i, j int) bool { return all[i].CreatedAt.After(all[j].CreatedAt) })
+
+	counts := map[JobState]int{}
+	out := []adminJob{}
+	for _, j := range all {
+		counts[j.State]++
+		if (state != "" && j.State != state) || (kind != "" && j.Kind != kind) || len(out) >= limit {
+			continue
+		}
+		out = append(out, adminJob{
+			ID:            j.ID,
+			Kind:          j.Kind,
+			State:         j.State,
+			Key:           j.Key,
+			Plan:          j.Plan,
+			Member:        j.Member,
+			CreatedAt:     j.CreatedAt,
+			StartedAt:     j.StartedAt,
+			FinishedAt:    j.FinishedAt,
+			Attempts:      j.Attempts,
+			QueuePosition: jobQueuePosition(j),
+			Error:         j.Error,
+			Model:         j.Model,
+			Usage:         j.Usage,
+			CostUSD:       j.CostUSD,
+		})
+	}
+
+	queues := map[JobKind]map[string]int{}
+	for _, k := range []JobKind{JobKindAdvisor, JobKindDetails} {
+		pending, running := queueFor(k).stats()
+		queues[k] = map[string]int{"pending": pending, "running": running}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"total":  len(all),
+		"counts": counts,
+		"queues": queues,
+		"jobs":   out,
+	})
+}
+
+// ---- Latency ----
+
+// Admin_Latency returns the latency and usage stats for both endpoints.
+//
+//	GET /admin/latency
+func Admin_Latency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	snapshot := func(ls *latencyStats) map[string]any {
+		ls.mu.Lock()
+		lifetime := ls.public()
+		lifetime.Samples = nil
+		ls.mu.Unlock()
+		return map[string]any{
+			"lifetime": lifetime,
+			"window":   ls.estimate(latencySegment{}),
+			"segments": ls.segments(),
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"advisor": snapshot(AdvisorLatency),
+		"details": snapshot(DetailsLatency),
+	})
+}
+
+// ---- Caches ----
+
+// adminCacheEntry is one file in a response or details cache.
+type adminCacheEntry struct {
+	Key        string    `json:"key"` // checksum or school slug
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+	AgeSeconds int64     `json:"age_seconds"`
+	Expired    bool      `json:"expired"`
+}
+
+// adminCaches maps the {cache} path segment to its location.
+var adminCaches = map[string]*cacheLocation{
+	"responses": &responseCache,
+	"details":   &detailsCache,
+}
+
+// Admin_Cache lists or purges cache entries.
+//
+//	GET    /admin/cache/{responses|details}
+//	DELETE /admin/cache/{responses|details}?key=<checksum|slug>
+//	DELETE /admin/cache/{responses|details}?older_than=24h
+//	DELETE /admin/cache/{responses|details}?all=true
+func Admin_Cache(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/cache/"), "/")
+	cache, ok := adminCaches[name]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown cache, want 'responses' or 'details'"})
+		return
+	}
+	dir := cache.Dir
+
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := listCacheEntries(dir, cache.TTL)
+		if err != nil {
+			logError("Admin_Cache", "Failed to list cache", slog.String("dir", dir), slog.Any("error", err))
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list cache"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"cache": name, "count": len(entries), "entries": entries})
+
+	case http.MethodDelete:
+		q := r.URL.Query()
+		key := strings.TrimSpace(q.Get("key"))
+		var olderThan time.Duration
+		if v := strings.TrimSpace(q.Get("older_than")); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "'older_than' must be a duration like 24h"})
+				return
+			}
+			olderThan = d
+		}
+		if key == "" && olderThan == 0 && q.Get("all") != "true" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "give 'key', 'older_than' or 'all=true'"})
+			return
+		}
+		if name == "details" && key != "" {
+			key = slugify(key) // accept a school name as well as its slug
+		}
+
+		entries, err := listCacheEntries(dir, cache.TTL)
+		if err != nil {
+			logError("Admin_Cache", "Failed to list cache", slog.String("dir", dir), slog.Any("error", err))
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list cache"})
+			return
+		}
+		removed := []string{}
+		for _, e := range entries {
+			if key != "" && e.Key != key {
+				continue
+			}
+			if olderThan > 0 && time.Duration(e.AgeSeconds)*time.Second < olderThan {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, e.Key+".json")); err != nil {
+				logWarn("Admin_Cache", "Failed to remove cache file",
+					slog.String("dir", dir), slog.String("key", e.Key), slog.Any("error", err))
+				continue
+			}
+			removed = append(removed, e.Key)
+		}
+		logDebug("Admin_Cache", "Purged cache files", slog.Int("removed", len(removed)), slog.String("cache", name))
+		writeJSON(w, http.StatusOK, map[string]any{"cache": name, "removed": removed})
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+func listCacheEntries(dir string, ttl time.Duration) ([]adminCacheEntry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []adminCacheEntry{}, nil
+		}
+		return nil, err
+	}
+	now := time.Now()
+	out := make([]adminCacheEntry, 0, len(files))
+	for _, f := range files {
+		key, ok := strings.CutSuffix(f.Name(), ".json")
+		if f.IsDir() || !ok {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		age := now.Sub(info.ModTime())
+		out = append(out, adminCacheEntry{
+			Key:        key,
+			Size:       info.Size(),
+			ModifiedAt: info.ModTime(),
+			AgeSeconds: int64(age.Seconds()),
+			Expired:    age > ttl,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ModifiedAt.After(out[j].ModifiedAt) })
+	return out, nil
+}
+
+// ---- Details refresh ----
+
+// Admin_RefreshDetails drops a school's cached details and queues a job to
+// regenerate them. Plan limits do not apply.
+//
+//	POST /admin/details/refresh {"school": "...", "profile": {...}}
+func Admin_RefreshDetails(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	defer r.Body.Close()
+
+	var req SchoolDetailsRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		return
+	}
+	school := strings.TrimSpace(req.School)
+	if school == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing 'school' name"})
+		return
+	}
+	slug := slugify(school)
+	if isDraining() {
+		writeDraining(w)
+		return
+	}
+
+	if err := os.Remove(cachePathForSchool(school)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logError("Admin_RefreshDetails", "Failed to drop cache", slog.String("school", school), slog.Any("error", err))
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to drop cache"})
+		return
+	}
+
+	id, err := genID()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate id"})
+		return
+	}
+	tagJobRequest(id, requestID(r))
+	defer untagOrphanRequest(id)
+	job := newJob(id, JobKindDetails, slug, req)
+	job.Member = "admin"
+	job.RequestID = requestID(r)
+	job.TraceParent = traceparent(r.Context())
+	if err := jobs.Create(job); err != nil {
+		logError("Admin_RefreshDetails", "Failed to create job", slog.String("job_id", id), slog.Any("error", err))
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create job"})
+		return
+	}
+	position, err := enqueueJob(job, false)
+	if err != nil {
+		jobs.Delete(id)
+		writeQueueFull(w, JobKindDetails)
+		return
+	}
+	logDebug("Admin_RefreshDetails", "Refresh queued",
+		slog.String("job_id", id), slog.String("school", school), slog.Int("queue_position", position))
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"id":             id,
+		"school":         slug,
+		"queue_position": position,
+	})
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =====================================================
+//                 Member authentication
+// =====================================================
+//
+// WithAuth verifies the member JWT in "Authorization: Bearer <jwt>" and puts
+// the Member on the request context. Keys are read from disk or the config
+// only, so verification works offline (members section, or these variables;
+// the secret lives in the secrets section):
+//
+//	MEMBER_JWKS_FILE      JWKS file with RS256 public keys (e.g. Memberstack's)
+//	MEMBER_JWT_SECRET     shared secret for HS256 tokens
+//	MEMBER_JWT_ISSUER     expected "iss" (optional)
+//	MEMBER_JWT_AUDIENCE   expected "aud" (optional)
+//	MEMBER_PRO_PLANS      comma-separated plan ids that count as Pro (besides "pro")
+
+// AuthMode is a route's authentication requirement.
+type AuthMode int
+
+const (
+	AuthOptional AuthMode = iota // anonymous callers are Free; a bad token is ignored
+	AuthRequired                 // a valid member token is required
+	AuthPro                      // a valid token for a Pro member is required
+)
+
+// jwtLeeway tolerates clock skew between the issuer and this server.
+const jwtLeeway = 30 * time.Second
+
+type memberContextKey struct{}
+
+// WithAuth wraps a handler with member authentication for the given mode.
+func WithAuth(mode AuthMode, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		member := Member{Plan: PlanFree}
+		token, hasToken := bearerToken(r)
+		if hasToken {
+			m, err := memberAuth().verify(token)
+			if err != nil {
+				logWarn("WithAuth", "Rejected member token", slog.String("remote_addr", r.RemoteAddr), slog.Any("error", err))
+				if mode != AuthOptional {
+					writeAuthError(w, http.StatusUnauthorized, "auth_invalid", "Your session has expired. Please log in again.")
+					return
+				}
+			} else {
+				member = m
+			}
+		}
+
+		switch {
+		case mode >= AuthRequired && member.ID == "":
+			writeAuthError(w, http.StatusUnauthorized, "auth_required", "Please log in to continue.")
+			return
+		case mode == AuthPro && member.Plan != PlanPro:
+			writeAuthError(w, http.StatusForbidden, "pro_required", "This feature is available on the Pro plan.")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), memberContextKey{}, member)))
+	})
+}
+
+func writeAuthError(w http.ResponseWriter, status int, code, msg string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="aidvisor"`)
+	}
+	writeJSON(w, status, map[string]string{"error": msg, "code": code})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	return token, ok && token != ""
+}
+
+// ---- Token verification ----
+
+var errInvalidToken = errors.New("invalid member token")
+
+type memberVerifier struct {
+	secret   []byte
+	keys     map[string]*rsa.PublicKey // by kid
+	issuer   string
+	audience string
+	proPlans []string
+}
+
+var (
+	memberAuthOnce sync.Once
+	memberAuthVal  *memberVerifier
+)
+
+// memberConfig is the members section of the config, set by
+// configureMembers.
+var memberConfig MembersConfig
+
+// configureMembers sets the JWKS file and the claims member tokens are
+// checked against. Call it before the first request; the verifier is built
+// once.
+func configureMembers(c MembersConfig) {
+	memberConfig = c
+}
+
+// memberAuth returns the verifier built on first use from the members
+// section of the config and the member JWT secret.
+func memberAuth() *memberVerifier {
+	memberAuthOnce.Do(func() {
+		v, err := newMemberVerifier(secretsConfig.MemberJWTSecret, memberConfig)
+		if err != nil {
+			logError("memberAuth", "Member tokens will be rejected", slog.Any("error", err))
+		}
+		memberAuthVal = v
+	})
+	return memberAuthVal
+}
+
+func newMemberVerifier(secret string, c MembersConfig) (*memberVerifier, error) {
+	v := &memberVerifier{
+		secret:   []byte(secret),
+		keys:     make(map[string]*rsa.PublicKey),
+		issuer:   strings.TrimSpace(c.JWTIssuer),
+		audience: strings.TrimSpace(c.JWTAudience),
+	}
+	for _, p := range c.ProPlans {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			v.proPlans = append(v.proPlans, p)
+		}
+	}
+
+	if path := strings.TrimSpace(c.JWKSFile); path != "" {
+		keys, err := loadJWKS(path)
+		if err != nil {
+			return v, err
+		}
+		v.keys = keys
+		logDebug("memberAuth", "Loaded RS256 keys", slog.Int("keys", len(keys)), slog.String("path", path))
+	}
+	if len(v.secret) == 0 && len(v.keys) == 0 {
+		return v, errors.New("neither MEMBER_JWKS_FILE nor MEMBER_JWT_SECRET is set")
+	}
+	return v, nil
+}
+
+// loadJWKS reads the RSA signing keys from a JWKS file.
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("jwks %s: %w", path, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, nerr := base64.RawURLEncoding.DecodeString(k.N)
+		e, eerr := base64.RawURLEncoding.DecodeString(k.E)
+		if nerr != nil || eerr != nil || len(e) > 4 {
+			logWarn("loadJWKS", "Skipping malformed key", slog.String("kid", k.Kid), slog.String("path", path))
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks %s: no usable RSA keys", path)
+	}
+	return keys, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// memberClaims are the JWT claims we read. Memberstack puts the member id in
+// "id"; other issuers use "sub".
+type memberClaims struct {
+	Sub   string          `json:"sub"`
+	ID    string          `json:"id"`
+	Plan  string          `json:"plan"`
+	Plans []string        `json:"plans"`
+	Iss   string          `json:"iss"`
+	Aud   json.RawMessage `json:"aud"` // string or array
+	Exp   int64           `json:"exp"`
+	Nbf   int64           `json:"nbf"`
+}
+
+func (v *memberVerifier) verify(token string) (Member, error) {
+	if v == nil {
+		return Member{}, errInvalidToken
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Member{}, errInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return Member{}, errInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Member{}, errInvalidToken
+	}
+	if err := v.checkSignature(header, parts[0]+"."+parts[1], sig); err != nil {
+		return Member{}, err
+	}
+
+	var claims memberClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return Member{}, errInvalidToken
+	}
+	now := time.Now()
+	if claims.Exp == 0 {
+		return Member{}, errors.New("member token has no expiry")
+	}
+	if now.After(time.Unix(claims.Exp, 0).Add(jwtLeeway)) {
+		return Member{}, errors.New("member token expired")
+	}
+	if claims.Nbf != 0 && now.Add(jwtLeeway).Before(time.Unix(claims.Nbf, 0)) {
+		return Member{}, errors.New("member token not yet valid")
+	}
+	if v.issuer != "" && claims.Iss != v.issuer {
+		return Member{}, fmt.Errorf("unexpected issuer %q", claims.Iss)
+	}
+	if v.audience != "" && !audienceContains(claims.Aud, v.audience) {
+		return Member{}, errors.New("token not issued for this audience")
+	}
+
+	id := claims.ID
+	if id == "" {
+		id = claims.Sub
+	}
+	if id == "" {
+		return Member{}, errInvalidToken
+	}
+	return Member{ID: id, Plan: v.planFor(claims)}, nil
+}
+
+func (v *memberVerifier) checkSignature(h jwtHeader, signed string, sig []byte) error {
+	switch h.Alg {
+	case "HS256":
+		if len(v.secret) == 0 {
+			return errors.New("HS256 token but MEMBER_JWT_SECRET is not set")
+		}
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return errInvalidToken
+		}
+		return nil
+	case "RS256":
+		key, ok := v.keys[h.Kid]
+		if !ok && h.Kid == "" && len(v.keys) == 1 {
+			for _, k := range v.keys {
+				key, ok = k, true
+			}
+		}
+		if !ok {
+			return fmt.Errorf("unknown signing key %q", h.Kid)
+		}
+		sum := sha256.Sum256([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+			return errInvalidToken
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", h.Alg)
+	}
+}
+
+// planFor maps the token's plan claims onto Free or Pro.
+func (v *memberVerifier) planFor(c memberClaims) Plan {
+	for _, p := range append([]string{c.Plan}, c.Plans...) {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == string(PlanPro) || (p != "" && slices.Contains(v.proPlans, p)) {
+			return PlanPro
+		}
+	}
+	return PlanFree
+}
+
+func audienceContains(raw json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == want
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		return slices.Contains(many, want)
+	}
+	return false
+}
+
+func decodeJWTPart(part string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// signJWT builds a token from header and claims, signing it with sign.
+func signJWT(t *testing.T, header, claims map[string]any, sign func(signed []byte) []byte) string {
+	t.Helper()
+	part := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := part(header) + "." + part(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func TestMemberVerifierVerify(t *testing.T) {
+	secret := []byte("test-secret")
+	hs256 := func(b []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(b)
+		return mac.Sum(nil)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs256 := func(b []byte) []byte {
+		sum := sha256.Sum256(b)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+
+	v := &memberVerifier{
+		secret:   secret,
+		keys:     map[string]*rsa.PublicKey{"k1": &key.PublicKey},
+		issuer:   "https://issuer.example",
+		audience: "aidvisor",
+		proPlans: []string{"pln_premium"},
+	}
+	now := time.Now().Unix()
+	claims := func(extra map[string]any) map[string]any {
+		c := map[string]any{"id": "mem_1", "iss": "https://issuer.example", "aud": "aidvisor", "exp": now + 3600}
+		for k, val := range extra {
+			if val == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = val
+		}
+		return c
+	}
+	hsHeader := map[string]any{"alg": "HS256"}
+
+	tests := []struct {
+		name    string
+		token   string
+		want    Member
+		wantErr bool
+	}{
+		{"hs256 free", signJWT(t, hsHeader, claims(nil), hs256), Member{ID: "mem_1", Plan: PlanFree}, false},
+		{"hs256 pro", signJWT(t, hsHeader, claims(map[string]any{"plan": "Pro"}), hs256), Member{ID: "mem_1", Plan: PlanPro}, false},
+		{"configured pro plan", signJWT(t, hsHeader, claims(map[string]any{"plans": []string{"pln_basic", "pln_premium"}}), hs256), Member{ID: "mem_1", Plan: PlanPro}, false},
+		{"sub when no id", signJWT(t, hsHeader, claims(map[string]any{"id": "", "sub": "user_2"}), hs256), Member{ID: "user_2", Plan: PlanFree}, false},
+		{"audience list", signJWT(t, hsHeader, claims(map[string]any{"aud": []string{"other", "aidvisor"}}), hs256), Member{ID: "mem_1", Plan: PlanFree}, false},
+		{"expired within leeway", signJWT(t, hsHeader, claims(map[string]any{"exp": now - 10}), hs256), Member{ID: "mem_1", Plan: PlanFree}, false},
+		{"rs256 by kid", signJWT(t, map[string]any{"alg": "RS256", "kid": "k1"}, claims(nil), rs256), Member{ID: "mem_1", Plan: PlanFree}, false},
+		{"rs256 single key without kid", signJWT(t, map[string]any{"alg": "RS256"}, claims(nil), rs256), Member{ID: "mem_1", Plan: PlanFree}, false},
+
+		{"bad hs256 signature", signJWT(t, hsHeader, claims(nil), func([]byte) []byte { return []byte("nope") }), Member{}, true},
+		{"rs256 unknown kid", signJWT(t, map[string]any{"alg": "RS256", "kid": "k2"}, claims(nil), rs256), Member{}, true},
+		{"rs256 signed with hmac", signJWT(t, map[string]any{"alg": "RS256", "kid": "k1"}, claims(nil), hs256), Member{}, true},
+		{"alg none", signJWT(t, map[string]any{"alg": "none"}, claims(nil), func([]byte) []byte { return nil }), Member{}, true},
+		{"no expiry", signJWT(t, hsHeader, claims(map[string]any{"exp": nil}), hs256), Member{}, true},
+		{"expired", signJWT(t, hsHeader, claims(map[string]any{"exp": now - 3600}), hs256), Member{}, true},
+		{"not yet valid", signJWT(t, hsHeader, claims(map[string]any{"nbf": now + 3600}), hs256), Member{}, true},
+		{"wrong issuer", signJWT(t, hsHeader, claims(map[string]any{"iss": "https://evil.example"}), hs256), Member{}, true},
+		{"wrong audience", signJWT(t, hsHeader, claims(map[string]any{"aud": "other"}), hs256), Member{}, true},
+		{"no member id", signJWT(t, hsHeader, claims(map[string]any{"id": ""}), hs256), Member{}, true},
+		{"two parts", "abc.def", Member{}, true},
+		{"garbage", "not a token", Member{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.verify(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("verify() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemberVerifierNil(t *testing.T) {
+	var v *memberVerifier
+	if _, err := v.verify("a.b.c"); err == nil {
+		t.Error("nil verifier accepted a token")
+	}
+}
+
+func TestMemberVerifierHS256WithoutSecret(t *testing.T) {
+	v := &memberVerifier{}
+	token := signJWT(t, map[string]any{"alg": "HS256"}, map[string]any{"id": "mem_1"}, func(b []byte) []byte {
+		mac := hmac.New(sha256.New, nil)
+		mac.Write(b)
+		return mac.Sum(nil)
+	})
+	if _, err := v.verify(token); err == nil {
+		t.Error("HS256 token accepted without a configured secret")
+	}
+}
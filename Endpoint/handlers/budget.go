@@ -0,0 +1,454 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =====================================================
+//              LLM spend budget and circuit breaker
+// =====================================================
+//
+// Every completion's token usage is priced and added to a daily and monthly
+// ledger (data/llm_spend.json). New jobs are refused while a budget is spent
+// or while the circuit breaker is open after repeated quota/rate errors.
+//
+//	LLM_PRICES_FILE            JSON price table overriding the defaults:
+//	                           {"gpt-5":{"input_per_mtok":1.25,"output_per_mtok":10}}
+//	LLM_BUDGET_DAILY_USD       global daily budget (unset = none)
+//	LLM_BUDGET_MONTHLY_USD     global monthly budget
+//	FREE_BUDGET_DAILY_USD      daily budget for Free-plan jobs (likewise PRO_, _MONTHLY_)
+//	LLM_BREAKER_THRESHOLD      consecutive quota/rate errors that open the breaker (default 3)
+//	LLM_BREAKER_COOLDOWN       how long it stays open before a trial call (default 1m)
+
+var (
+	errBudgetExceeded = errors.New("LLM spend budget exhausted")
+	errCircuitOpen    = errors.New("LLM circuit breaker open")
+)
+
+// ---- Prices ----
+
+// llmPrice is USD per million tokens. Reasoning tokens are billed as output
+// and are already included in completion tokens.
+type llmPrice struct {
+	InputPerMTok  float64 `json:"input_per_mtok"`
+	OutputPerMTok float64 `json:"output_per_mtok"`
+}
+
+var defaultLLMPrices = map[string]llmPrice{
+	"gpt-5":      {InputPerMTok: 1.25, OutputPerMTok: 10},
+	"gpt-5-mini": {InputPerMTok: 0.25, OutputPerMTok: 2},
+	"gpt-5-nano": {InputPerMTok: 0.05, OutputPerMTok: 0.40},
+
+	// Offline providers report these model names and never cost anything.
+	"fake":    {},
+	"fixture": {},
+}
+
+var (
+	llmPricesFile string // limits.prices_file, set by configureBudgets
+	llmPricesOnce sync.Once
+	llmPrices     map[string]llmPrice
+)
+
+func priceTable() map[string]llmPrice {
+	llmPricesOnce.Do(func() {
+		llmPrices = make(map[string]llmPrice, len(defaultLLMPrices))
+		for k, v := range defaultLLMPrices {
+			llmPrices[k] = v
+		}
+		path := strings.TrimSpace(llmPricesFile)
+		if path == "" {
+			return
+		}
+		custom, err := readPriceTable(path)
+		if err != nil {
+			logWarn("priceTable", "Ignoring price table", slog.String("path", path), slog.Any("error", err))
+			return
+		}
+		for k, v := range custom {
+			llmPrices[strings.ToLower(k)] = v
+		}
+	})
+	return llmPrices
+}
+
+// readPriceTable reads a JSON price table; Validate uses it to reject a
+// broken file at startup.
+func readPriceTable(path string) (map[string]llmPrice, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var custom map[string]llmPrice
+	if err := json.Unmarshal(b, &custom); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return custom, nil
+}
+
+// llmCost prices a completion. Dated snapshots ("gpt-5-2025-08-07") fall
+// back to the longest matching model prefix; unknown models cost 0.
+func llmCost(model string, u LLMUsage) float64 {
+	model = strings.ToLower(model)
+	var price llmPrice
+	best := -1
+	for name, p := range priceTable() {
+		if (model == name || strings.HasPrefix(model, name+"-")) && len(name) > best {
+			price, best = p, len(name)
+		}
+	}
+	if best < 0 {
+		return 0
+	}
+	return (float64(u.PromptTokens)*price.InputPerMTok + float64(u.CompletionTokens)*price.OutputPerMTok) / 1e6
+}
+
+// ---- Spend ledger ----
+
+const llmSpendPath = "data/llm_spend.json"
+
+type spendTotals struct {
+	USD    float64          `json:"usd"`
+	ByPlan map[Plan]float64 `json:"by_plan,omitempty"`
+}
+
+type spendLedger struct {
+	mu     sync.Mutex
+	path   string
+	Days   map[string]*spendTotals `json:"days"`   // 2006-01-02
+	Months map[string]*spendTotals `json:"months"` // 2006-01
+}
+
+var llmSpend = newSpendLedger(llmSpendPath)
+
+func newSpendLedger(path string) *spendLedger {
+	l := &spendLedger{
+		path:   path,
+		Days:   make(map[string]*spendTotals),
+		Months: make(map[string]*spendTotals),
+	}
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, l); err != nil {
+			logWarn("llmSpend", "Ignoring unreadable file", slog.String("path", path), slog.Any("error", err))
+		}
+	}
+	if l.Days == nil {
+		l.Days = make(map[string]*spendTotals)
+	}
+	if l.Months == nil {
+		l.Months = make(map[string]*spendTotals)
+	}
+	return l
+}
+
+func spendPeriods(t time.Time) (day, month string) {
+	t = t.UTC()
+	return t.Format("2006-01-02"), t.Format("2006-01")
+}
+
+func (l *spendLedger) add(plan Plan, usd float64, now time.Time) {
+	if usd <= 0 {
+		return
+	}
+	day, month := spendPeriods(now)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, t := range []*spendTotals{l.totals(l.Days, day), l.totals(l.Months, month)} {
+		t.USD += usd
+		if t.ByPlan == nil {
+			t.ByPlan = make(map[Plan]float64)
+		}
+		t.ByPlan[plan] += usd
+	}
+	l.pruneLocked(now)
+	l.saveLocked()
+}
+
+func (l *spendLedger) totals(m map[string]*spendTotals, key string) *spendTotals {
+	t := m[key]
+	if t == nil {
+		t = &spendTotals{}
+		m[key] = t
+	}
+	return t
+}
+
+// spent returns what has been spent today and this month, globally and for plan.
+func (l *spendLedger) spent(plan Plan, now time.Time) (day, month, planDay, planMonth float64) {
+	d, m := spendPeriods(now)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t := l.Days[d]; t != nil {
+		day, planDay = t.USD, t.ByPlan[plan]
+	}
+	if t := l.Months[m]; t != nil {
+		month, planMonth = t.USD, t.ByPlan[plan]
+	}
+	return
+}
+
+// pruneLocked keeps roughly a year of history.
+func (l *spendLedger) pruneLocked(now time.Time) {
+	cutoffDay, cutoffMonth := spendPeriods(now.AddDate(-1, 0, 0))
+	for k := range l.Days {
+		if k < cutoffDay {
+			delete(l.Days, k)
+		}
+	}
+	for k := range l.Months {
+		if k < cutoffMonth {
+			delete(l.Months, k)
+		}
+	}
+}
+
+func (l *spendLedger) saveLocked() {
+	err := os.MkdirAll(filepath.Dir(l.path), 0o755)
+	var data []byte
+	if err == nil {
+		data, err = json.MarshalIndent(l, "", "  ")
+	}
+	if err == nil {
+		err = writeFileAtomic(l.path, data, 0o644)
+	}
+	if err != nil {
+		logWarn("llmSpend", "Failed to save ledger", slog.String("path", l.path), slog.Any("error", err))
+	}
+}
+
+// jobPlan is the plan a job is billed to; jobs from before plans were
+// recorded count as Free.
+func jobPlan(id string) Plan {
+	if job, ok := jobs.Get(id); ok && job.Plan != "" {
+		return job.Plan
+	}
+	return PlanFree
+}
+
+// chargeLLM prices a job's token usage and adds it to the ledger under the
+// job's plan. It returns the cost in USD.
+func chargeLLM(id string, model string, usage LLMUsage) float64 {
+	cost := llmCost(model, usage)
+	plan := jobPlan(id)
+	llmSpend.add(plan, cost, time.Now())
+	logDebug("chargeLLM", "Charged LLM usage",
+		slog.String("job_id", id), slog.Int64("prompt_tokens", usage.PromptTokens), slog.Int64("completion_tokens", usage.CompletionTokens), slog.String("model", model), slog.Float64("cost_usd", cost), slog.String("plan", string(plan)))
+	return cost
+}
+
+// ---- Budgets ----
+
+type spendBudget struct {
+	DailyUSD   float64
+	MonthlyUSD float64
+}
+
+// spendBudgets holds the global budget under "" and each plan's under its
+// name; 0 is not enforced. Set by configureBudgets.
+var spendBudgets = map[Plan]spendBudget{}
+
+// configureBudgets sets the spend budgets from the limits section of the
+// config.
+func configureBudgets(c LimitsConfig) {
+	llmPricesFile = c.PricesFile
+	llmBreaker.configure(c.BreakerThreshold, time.Duration(c.BreakerCooldown))
+	spendBudgets = map[Plan]spendBudget{
+		"":       {DailyUSD: c.BudgetDailyUSD, MonthlyUSD: c.BudgetMonthlyUSD},
+		PlanFree: {DailyUSD: c.FreeBudgetDailyUSD, MonthlyUSD: c.FreeBudgetMonthlyUSD},
+		PlanPro:  {DailyUSD: c.ProBudgetDailyUSD, MonthlyUSD: c.ProBudgetMonthlyUSD},
+	}
+}
+
+// checkBudget reports errBudgetExceeded if any budget that applies to plan
+// is used up. Budgets of 0 are not enforced.
+func checkBudget(plan Plan) error {
+	day, month, planDay, planMonth := llmSpend.spent(plan, time.Now())
+	prefix := strings.ToUpper(string(plan)) + "_BUDGET_"
+	all, own := spendBudgets[""], spendBudgets[plan]
+	checks := []struct {
+		name         string
+		spent, limit float64
+	}{
+		{"LLM_BUDGET_DAILY_USD", day, all.DailyUSD},
+		{"LLM_BUDGET_MONTHLY_USD", month, all.MonthlyUSD},
+		{prefix + "DAILY_USD", planDay, own.DailyUSD},
+		{prefix + "MONTHLY_USD", planMonth, own.MonthlyUSD},
+	}
+	for _, c := range checks {
+		if c.limit > 0 && c.spent >= c.limit {
+			return fmt.Errorf("%w: %s $%.2f of $%.2f", errBudgetExceeded, c.name, c.spent, c.limit)
+		}
+	}
+	return nil
+}
+
+// llmAvailable reports why a new job for plan should not be started, if at all.
+func llmAvailable(plan Plan) error {
+	if err := llmBreaker.allow(false); err != nil {
+		return err
+	}
+	return checkBudget(plan)
+}
+
+// writeLLMUnavailable fails a request fast instead of queueing a job that
+// could only fail. Cached results are still served before this is reached.
+func writeLLMUnavailable(w http.ResponseWriter, err error) {
+	retry := ceilSeconds(llmBreaker.retryAfter())
+	if errors.Is(err, errBudgetExceeded) {
+		retry = ceilSeconds(time.Until(nextUTCMidnight(time.Now())))
+	}
+	if retry > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+	}
+	writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+		"error":       sanitizeOpenAIError(err),
+		"code":        "llm_unavailable",
+		"retry_after": retry,
+	})
+}
+
+func nextUTCMidnight(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// ---- Circuit breaker ----
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+const (
+	defaultBreakerThreshold = 3
+	defaultBreakerCooldown  = time.Minute
+)
+
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int           // consecutive quota/rate errors that open it
+	cooldown  time.Duration // how long it stays open before a trial call
+
+	state    breakerState
+	failures int
+	openedAt time.Time
+	trial    bool // a half-open trial call is in flight
+	lastOK   time.Time
+}
+
+var llmBreaker = &circuitBreaker{threshold: defaultBreakerThreshold, cooldown: defaultBreakerCooldown}
+
+func (b *circuitBreaker) configure(threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threshold, b.cooldown = threshold, cooldown
+}
+
+// allow reports errCircuitOpen while the breaker is open. Once the cooldown
+// has passed it half-opens and lets a single trial call through; call=false
+// only peeks, for handlers deciding whether to accept a job.
+func (b *circuitBreaker) allow(call bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.state = breakerHalfOpen
+		b.trial = false
+		logWarn("circuitBreaker", "Half-open: allowing a trial LLM call")
+	}
+	switch b.state {
+	case breakerOpen:
+		return errCircuitOpen
+	case breakerHalfOpen:
+		if !call {
+			return nil
+		}
+		if b.trial {
+			return errCircuitOpen
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// record feeds a call's outcome into the breaker. Only a success closes it.
+// Quota and rate-limit errors count toward opening it; other errors say
+// nothing about quota and leave it as it is, except that a failed half-open
+// trial, whatever the error, opens it again.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		if b.state != breakerClosed {
+			logDebug("circuitBreaker", "Closed")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		b.trial = false
+		b.lastOK = time.Now()
+		return
+	}
+	quota := isQuotaError(err) || isRateLimitError(err)
+	if quota {
+		b.failures++
+	}
+	switch {
+	case b.state == breakerHalfOpen:
+		b.open(err)
+	case quota && b.failures >= b.threshold:
+		b.open(err)
+	}
+}
+
+// open trips the breaker; b.mu must be held.
+func (b *circuitBreaker) open(err error) {
+	logError("circuitBreaker", "Open",
+		slog.Int("failures", b.failures), slog.Bool("trial_failed", b.state == breakerHalfOpen), slog.Any("error", err))
+	b.state = breakerOpen
+	b.openedAt = time.Now()
+	b.trial = false
+}
+
+// retryAfter is how long until the breaker next lets a call through.
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerOpen {
+		return 0
+	}
+	return max(0, b.cooldown-time.Since(b.openedAt))
+}
+
+// status returns the breaker state, consecutive failures and the time of the
+// last successful call (zero if none yet).
+func (b *circuitBreaker) status() (state breakerState, failures int, lastOK time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.failures, b.lastOK
+}
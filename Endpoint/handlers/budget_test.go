@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var (
+		quota = errors.New("429: insufficient_quota")
+		rate  = errors.New("429 Too Many Requests: rate_limit_exceeded")
+		other = errors.New("500: upstream timeout")
+	)
+	// A step records err, or with allow set asks to make a call and expects
+	// wantErr, then checks the state and failure count.
+	type step struct {
+		allow    bool
+		err      error
+		cool     bool // let the cooldown pass first
+		wantErr  bool
+		state    breakerState
+		failures int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"opens at the threshold", []step{
+			{err: quota, state: breakerClosed, failures: 1},
+			{err: rate, state: breakerClosed, failures: 2},
+			{err: quota, state: breakerOpen, failures: 3},
+			{allow: true, wantErr: true, state: breakerOpen, failures: 3},
+		}},
+		{"success resets the count", []step{
+			{err: quota, state: breakerClosed, failures: 1},
+			{err: quota, state: breakerClosed, failures: 2},
+			{err: nil, state: breakerClosed, failures: 0},
+			{err: quota, state: breakerClosed, failures: 1},
+		}},
+		{"other errors leave it alone", []step{
+			{err: quota, state: breakerClosed, failures: 1},
+			{err: other, state: breakerClosed, failures: 1},
+			{err: quota, state: breakerClosed, failures: 2},
+			{err: quota, state: breakerOpen, failures: 3},
+			{err: other, state: breakerOpen, failures: 3},
+		}},
+		{"half-open success closes", []step{
+			{err: quota, state: breakerClosed, failures: 1},
+			{err: quota, state: breakerClosed, failures: 2},
+			{err: quota, state: breakerOpen, failures: 3},
+			{cool: true, allow: true, state: breakerHalfOpen, failures: 3},
+			{allow: true, wantErr: true, state: breakerHalfOpen, failures: 3}, // one trial at a time
+			{err: nil, state: breakerClosed, failures: 0},
+		}},
+		{"half-open quota error reopens", []step{
+			{err: quota, state: breakerClosed, failures: 1},
+			{err: quota, state: breakerClosed, failures: 2},
+			{err: quota, state: breakerOpen, failures: 3},
+			{cool: true, allow: true, state: breakerHalfOpen, failures: 3},
+			{err: quota, state: breakerOpen, failures: 4},
+		}},
+		{"half-open other error reopens", []step{
+			{err: quota, state: breakerClosed, failures: 1},
+			{err: quota, state: breakerClosed, failures: 2},
+			{err: quota, state: breakerOpen, failures: 3},
+			{cool: true, allow: true, state: breakerHalfOpen, failures: 3},
+			{err: other, state: breakerOpen, failures: 3},
+			{allow: true, wantErr: true, state: breakerOpen, failures: 3},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &circuitBreaker{threshold: 3, cooldown: time.Minute}
+			for i, s := range tt.steps {
+				if s.cool {
+					b.openedAt = b.openedAt.Add(-b.cooldown)
+				}
+				if s.allow {
+					if err := b.allow(true); (err != nil) != s.wantErr {
+						t.Fatalf("step %d: allow() = %v, wantErr %v", i, err, s.wantErr)
+					}
+				} else {
+					b.record(s.err)
+				}
+				if state, failures, _ := b.status(); state != s.state || failures != s.failures {
+					t.Fatalf("step %d: state %s with %d failure(s), want %s with %d", i, state, failures, s.state, s.failures)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckBudget(t *testing.T) {
+	prevSpend, prevBudgets := llmSpend, spendBudgets
+	t.Cleanup(func() { llmSpend, spendBudgets = prevSpend, prevBudgets })
+
+	path := filepath.Join(t.TempDir(), "llm_spend.json")
+	llmSpend = newSpendLedger(path)
+	spendBudgets = map[Plan]spendBudget{
+		"":       {DailyUSD: 10, MonthlyUSD: 100},
+		PlanFree: {DailyUSD: 1},
+	}
+	now := time.Now()
+
+	if err := checkBudget(PlanFree); err != nil {
+		t.Fatalf("nothing spent: %v", err)
+	}
+	llmSpend.add(PlanFree, 0.5, now)
+	if err := checkBudget(PlanFree); err != nil {
+		t.Fatalf("under the Free budget: %v", err)
+	}
+	llmSpend.add(PlanFree, 0.5, now)
+	if err := checkBudget(PlanFree); !errors.Is(err, errBudgetExceeded) {
+		t.Errorf("Free budget spent: checkBudget(free) = %v, want errBudgetExceeded", err)
+	}
+	if err := checkBudget(PlanPro); err != nil {
+		t.Errorf("Free spend blocked Pro: %v", err)
+	}
+	llmSpend.add(PlanPro, 9, now)
+	if err := checkBudget(PlanPro); !errors.Is(err, errBudgetExceeded) {
+		t.Errorf("global daily budget spent: checkBudget(pro) = %v, want errBudgetExceeded", err)
+	}
+	if err := checkBudget(PlanPro); err == nil || !strings.Contains(err.Error(), "LLM_BUDGET_DAILY_USD") {
+		t.Errorf("error %v does not name the global daily budget", err)
+	}
+
+	// The ledger survives a restart, and today's spend does not count
+	// against tomorrow's budget.
+	tomorrow := now.Add(24 * time.Hour)
+	if day, month, _, _ := newSpendLedger(path).spent(PlanPro, now); day != 10 || month != 10 {
+		t.Errorf("reloaded ledger: day $%.2f, month $%.2f; want $10 and $10", day, month)
+	}
+	if day, _, _, _ := llmSpend.spent(PlanPro, tomorrow); day != 0 {
+		t.Errorf("tomorrow: day $%.2f, want 0", day)
+	}
+}
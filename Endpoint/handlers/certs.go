@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// =====================================================
+//              Reloadable TLS certificate
+// =====================================================
+//
+// CertManager serves the certificate through tls.Config.GetCertificate and
+// swaps in a new one when the cert or key file changes (checked every
+// RELOAD_INTERVAL) or on SIGHUP, so a renewal needs no restart. A pair that
+// fails to load keeps the current certificate.
+
+type CertManager struct {
+	certFile, keyFile string
+
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	stamp [2]fileStamp
+}
+
+// NewCertManager loads the key pair and checks the files for changes every
+// interval (0 = only on Reload).
+func NewCertManager(certFile, keyFile string, interval time.Duration) (*CertManager, error) {
+	m := &CertManager{certFile: certFile, keyFile: keyFile}
+	if err := m.Reload("startup"); err != nil {
+		return nil, err
+	}
+	go watchFiles(interval, m.changed, func() { _ = m.Reload("file changed") })
+	return m, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (m *CertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert, nil
+}
+
+// TLSConfig returns a server TLS config backed by m.
+func (m *CertManager) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: m.GetCertificate, MinVersion: tls.VersionTLS12}
+}
+
+// Reload re-reads the key pair and logs the outcome.
+func (m *CertManager) Reload(reason string) error {
+	stamp := [2]fileStamp{stampOf(m.certFile), stampOf(m.keyFile)}
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err == nil && cert.Leaf == nil && len(cert.Certificate) > 0 {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	}
+
+	m.mu.Lock()
+	m.stamp = stamp // a broken pair is retried once the files change again
+	if err != nil {
+		hadCert := m.cert != nil
+		m.mu.Unlock()
+		if hadCert {
+			logError("CertManager", "Reload failed, keeping current certificate",
+				slog.String("reason", reason), slog.Any("error", err), logFail)
+		}
+		return fmt.Errorf("load TLS key pair: %w", err)
+	}
+	m.cert = &cert
+	m.mu.Unlock()
+
+	logInfo("CertManager", "Loaded certificate",
+		slog.Any("names", cert.Leaf.DNSNames), slog.String("path", m.certFile), slog.Time("expires", cert.Leaf.NotAfter), slog.String("reason", reason), logOK)
+	return nil
+}
+
+func (m *CertManager) changed() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return [2]fileStamp{stampOf(m.certFile), stampOf(m.keyFile)} != m.stamp
+}
@@ -0,0 +1,670 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =====================================================
+//                   Server configuration
+// =====================================================
+//
+// Settings are merged in layers, later ones winning:
+//
+//	1. built-in defaults
+//	2. a JSON or YAML file (-config, or CONFIG_FILE)
+//	3. environment variables (each setting's `env` tag below; PORT=8443 is
+//	   shorthand for LISTEN_ADDR=:8443)
+//	4. flags: -mode, -addr, -port, -redirect-addr, -tls-cert, -tls-key, -origins
+//
+// Apply hands each section to the subsystem that uses it (queue.go, plans.go,
+// ratelimit.go, budget.go and friends); until then they run on the defaults.
+// The job store is opened separately with OpenJobStore. Settings tagged
+// secret are redacted when the config is printed.
+
+// Config is the whole server configuration.
+type Config struct {
+	Listen   ListenConfig   `json:"listen"`
+	CORS     CORSConfig     `json:"cors"`
+	Models   ModelsConfig   `json:"models"`
+	Timeouts TimeoutsConfig `json:"timeouts"`
+	Cache    CacheConfig    `json:"cache"`
+	Jobs     JobsConfig     `json:"jobs"`
+	Limits   LimitsConfig   `json:"limits"`
+	Secrets  SecretsConfig  `json:"secrets"`
+	Members  MembersConfig  `json:"members"`
+	Latency  LatencyConfig  `json:"latency"`
+	Logging  LoggingConfig  `json:"logging"`
+	Tracing  TracingConfig  `json:"tracing"`
+}
+
+type ListenConfig struct {
+	Mode           string    `json:"mode" env:"LISTEN_MODE"` // tls | http
+	Addr           string    `json:"addr" env:"LISTEN_ADDR"`
+	RedirectAddr   string    `json:"redirect_addr" env:"REDIRECT_ADDR"` // tls mode: HTTP listener that redirects to HTTPS
+	Host           string    `json:"host" env:"PUBLIC_HOST"`            // public host name, for the startup log
+	TLS            TLSConfig `json:"tls"`
+	TrustedProxies []string  `json:"trusted_proxies" env:"TRUSTED_PROXIES"`
+}
+
+// Listen modes.
+const (
+	ListenTLS  = "tls"
+	ListenHTTP = "http"
+)
+
+type TLSConfig struct {
+	CertFile string `json:"cert_file" env:"TLS_CERT_FILE"`
+	KeyFile  string `json:"key_file" env:"TLS_KEY_FILE"`
+}
+
+type CORSConfig struct {
+	Origins []string `json:"origins" env:"CORS_ORIGINS"`
+}
+
+type ModelsConfig struct {
+	Provider        string        `json:"provider" env:"LLM_PROVIDER"`
+	AdvisorProvider string        `json:"advisor_provider" env:"ADVISOR_LLM_PROVIDER"` // empty = provider
+	DetailsProvider string        `json:"details_provider" env:"DETAILS_LLM_PROVIDER"` // empty = provider
+	Default         string        `json:"default" env:"LLM_MODEL"`
+	Advisor         string        `json:"advisor" env:"ADVISOR_MODEL"` // empty = default
+	Details         string        `json:"details" env:"DETAILS_MODEL"` // empty = default
+	LocalBaseURL    string        `json:"local_base_url" env:"LLM_LOCAL_BASE_URL"`
+	LocalAPIKey     string        `json:"local_api_key" env:"LLM_LOCAL_API_KEY" secret:"true"`
+	Fixture         FixtureConfig `json:"fixture"`
+}
+
+type FixtureConfig struct {
+	Dir    string `json:"dir" env:"LLM_FIXTURE_DIR"`
+	Mode   string `json:"mode" env:"LLM_FIXTURE_MODE"`     // replay | record
+	Source string `json:"source" env:"LLM_FIXTURE_SOURCE"` // provider recorded from
+}
+
+type TimeoutsConfig struct {
+	Advisor    Duration `json:"advisor" env:"ADVISOR_TIMEOUT"` // one advisor job's LLM call
+	Details    Duration `json:"details" env:"DETAILS_TIMEOUT"` // one details job's LLM call
+	ReadHeader Duration `json:"read_header" env:"HTTP_READ_HEADER_TIMEOUT"`
+	Idle       Duration `json:"idle" env:"HTTP_IDLE_TIMEOUT"`
+	Shutdown   Duration `json:"shutdown" env:"SHUTDOWN_TIMEOUT"` // drain deadline on SIGTERM
+}
+
+type CacheConfig struct {
+	ResponsesDir string   `json:"responses_dir" env:"RESPONSE_CACHE_DIR"`
+	ResponsesTTL Duration `json:"responses_ttl" env:"RESPONSE_CACHE_TTL"`
+	DetailsDir   string   `json:"details_dir" env:"DETAILS_CACHE_DIR"`
+	DetailsTTL   Duration `json:"details_ttl" env:"DETAILS_CACHE_TTL"`
+}
+
+type JobsConfig struct {
+	Store             string `json:"store" env:"JOB_STORE"` // file | memory
+	StoreDir          string `json:"store_dir" env:"JOB_STORE_DIR"`
+	AdvisorWorkers    int    `json:"advisor_workers" env:"ADVISOR_WORKERS"`
+	AdvisorQueueDepth int    `json:"advisor_queue_depth" env:"ADVISOR_QUEUE_DEPTH"`
+	DetailsWorkers    int    `json:"details_workers" env:"DETAILS_WORKERS"`
+	DetailsQueueDepth int    `json:"details_queue_depth" env:"DETAILS_QUEUE_DEPTH"`
+}
+
+type LimitsConfig struct {
+	LLMMaxAttempts int `json:"llm_max_attempts" env:"LLM_MAX_ATTEMPTS"`
+
+	// Plan limits; -1 = unlimited.
+	FreeMaxSchools int `json:"free_max_schools" env:"FREE_MAX_SCHOOLS"`
+	FreeMaxRuns    int `json:"free_max_runs" env:"FREE_MAX_RUNS"`
+	FreeMaxDetails int `json:"free_max_details" env:"FREE_MAX_DETAILS"`
+	ProMaxSchools  int `json:"pro_max_schools" env:"PRO_MAX_SCHOOLS"`
+	ProMaxRuns     int `json:"pro_max_runs" env:"PRO_MAX_RUNS"`
+	ProMaxDetails  int `json:"pro_max_details" env:"PRO_MAX_DETAILS"`
+
+	// How often plan allowances start over; 0 = never.
+	PlanUsagePeriod Duration `json:"plan_usage_period" env:"PLAN_USAGE_PERIOD"`
+
+	// Rate limits as <requests>/<window>.
+	RateAdvisor string `json:"rate_advisor" env:"RATE_LIMIT_ADVISOR"`
+	RateDetails string `json:"rate_details" env:"RATE_LIMIT_DETAILS"`
+	RatePoll    string `json:"rate_poll" env:"RATE_LIMIT_POLL"`
+
+	// Pricing and the circuit breaker in front of the LLM API.
+	PricesFile       string   `json:"prices_file" env:"LLM_PRICES_FILE"`
+	BreakerThreshold int      `json:"breaker_threshold" env:"LLM_BREAKER_THRESHOLD"`
+	BreakerCooldown  Duration `json:"breaker_cooldown" env:"LLM_BREAKER_COOLDOWN"`
+
+	// Spend budgets in USD; 0 = not enforced.
+	BudgetDailyUSD       float64 `json:"budget_daily_usd" env:"LLM_BUDGET_DAILY_USD"`
+	BudgetMonthlyUSD     float64 `json:"budget_monthly_usd" env:"LLM_BUDGET_MONTHLY_USD"`
+	FreeBudgetDailyUSD   float64 `json:"free_budget_daily_usd" env:"FREE_BUDGET_DAILY_USD"`
+	FreeBudgetMonthlyUSD float64 `json:"free_budget_monthly_usd" env:"FREE_BUDGET_MONTHLY_USD"`
+	ProBudgetDailyUSD    float64 `json:"pro_budget_daily_usd" env:"PRO_BUDGET_DAILY_USD"`
+	ProBudgetMonthlyUSD  float64 `json:"pro_budget_monthly_usd" env:"PRO_BUDGET_MONTHLY_USD"`
+}
+
+type SecretsConfig struct {
+	// How often the TLS and secrets files are checked for changes; 0 = only
+	// on SIGHUP.
+	ReloadInterval Duration `json:"reload_interval" env:"RELOAD_INTERVAL"`
+
+	OpenAIKeyFile   string `json:"openai_key_file" env:"OPENAI_SECRETS_PATH"`
+	OpenAIAPIKey    string `json:"openai_api_key" env:"OPENAI_API_KEY" secret:"true"`
+	AdminToken      string `json:"admin_token" env:"ADMIN_TOKEN" secret:"true"`
+	MetricsToken    string `json:"metrics_token" env:"METRICS_TOKEN" secret:"true"`
+	MemberJWTSecret string `json:"member_jwt_secret" env:"MEMBER_JWT_SECRET" secret:"true"`
+}
+
+type MembersConfig struct {
+	JWKSFile    string   `json:"jwks_file" env:"MEMBER_JWKS_FILE"`
+	JWTIssuer   string   `json:"jwt_issuer" env:"MEMBER_JWT_ISSUER"`     // empty = not checked
+	JWTAudience string   `json:"jwt_audience" env:"MEMBER_JWT_AUDIENCE"` // empty = not checked
+	ProPlans    []string `json:"pro_plans" env:"MEMBER_PRO_PLANS"`       // plan ids counted as Pro besides "pro"
+}
+
+type LatencyConfig struct {
+	Window        Duration `json:"window" env:"LATENCY_WINDOW"`                 // preferred for estimates
+	WindowSamples int      `json:"window_samples" env:"LATENCY_WINDOW_SAMPLES"` // kept per endpoint
+}
+
+type LoggingConfig struct {
+	Level  string `json:"level" env:"LOG_LEVEL"`   // debug | info | warn | error
+	Format string `json:"format" env:"LOG_FORMAT"` // text | json
+}
+
+type TracingConfig struct {
+	Exporter       string `json:"exporter" env:"OTEL_TRACES_EXPORTER"` // none | stdout | otlp
+	Endpoint       string `json:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	TracesEndpoint string `json:"traces_endpoint" env:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"` // empty = <endpoint>/v1/traces
+	Headers        string `json:"headers" env:"OTEL_EXPORTER_OTLP_HEADERS" secret:"true"`   // "key=value,key2=value2"
+	ServiceName    string `json:"service_name" env:"OTEL_SERVICE_NAME"`
+}
+
+// Duration is a time.Duration written as "10m" or "168h" in config files.
+type Duration time.Duration
+
+func (d Duration) String() string { return shortDuration(time.Duration(d)) }
+
+func (d Duration) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(strings.TrimSpace(string(b)))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DefaultConfig returns the settings used when nothing overrides them.
+func DefaultConfig() Config {
+	free, pro := defaultPlanLimits[PlanFree], defaultPlanLimits[PlanPro]
+	rate := func(c RateClass) string {
+		b := defaultRateBudgets[c]
+		return fmt.Sprintf("%d/%s", b.Limit, shortDuration(b.Window))
+	}
+	return Config{
+		Listen: ListenConfig{
+			Mode: ListenTLS,
+			Addr: ":443",
+			Host: "developertesting.xyz",
+			TLS: TLSConfig{
+				CertFile: "/etc/letsencrypt/live/developertesting.xyz/fullchain.pem",
+				KeyFile:  "/etc/letsencrypt/live/developertesting.xyz/privkey.pem",
+			},
+		},
+		CORS: CORSConfig{Origins: []string{
+			"https://my-aidvisor.webflow.io",
+			"https://www.auroramentor.ai",
+		}},
+		Models: llmModels,
+		Timeouts: TimeoutsConfig{
+			Advisor:    Duration(advisorJobTimeout),
+			Details:    Duration(detailsJobTimeout),
+			ReadHeader: Duration(10 * time.Second),
+			Idle:       Duration(2 * time.Minute),
+			Shutdown:   Duration(2 * time.Minute),
+		},
+		Cache: CacheConfig{
+			ResponsesDir: responseCache.Dir,
+			ResponsesTTL: Duration(responseCache.TTL),
+			DetailsDir:   detailsCache.Dir,
+			DetailsTTL:   Duration(detailsCache.TTL),
+		},
+		Jobs: jobQueueConfig,
+		Limits: LimitsConfig{
+			LLMMaxAttempts: defaultLLMMaxAttempts,
+			FreeMaxSchools: free.MaxSchools,
+			FreeMaxRuns:    free.MaxRuns,
+			FreeMaxDetails: free.MaxDetails,
+			ProMaxSchools:  pro.MaxSchools,
+			ProMaxRuns:     pro.MaxRuns,
+			ProMaxDetails:  pro.MaxDetails,
+			RateAdvisor:    rate(RateAdvisor),
+			RateDetails:    rate(RateDetails),
+			RatePoll:       rate(RatePoll),
+
+			PricesFile:       llmPricesFile,
+			BreakerThreshold: llmBreaker.threshold,
+			BreakerCooldown:  Duration(llmBreaker.cooldown),
+		},
+		Secrets: secretsConfig,
+		Members: memberConfig,
+		Latency: latencyConfig,
+		Logging: LoggingConfig{Level: "info", Format: "text"},
+		Tracing: tracingConfig,
+	}
+}
+
+// LoadConfig merges defaults, the config file, the environment and the
+// flags in args (usually os.Args[1:]), then validates the result.
+func LoadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON or YAML config `file`")
+	mode := fs.String("mode", "", "listen `mode`: tls or http")
+	addr := fs.String("addr", "", "listen `address`, e.g. :8443")
+	redirect := fs.String("redirect-addr", "", "tls mode: also redirect HTTP on this `address` to HTTPS")
+	port := fs.String("port", "", "`port` to listen on (shorthand for -addr :PORT)")
+	cert := fs.String("tls-cert", "", "TLS certificate `file`")
+	key := fs.String("tls-key", "", "TLS private key `file`")
+	origins := fs.String("origins", "", "comma-separated CORS `origins`")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+	if p := strings.TrimSpace(*path); p != "" {
+		if err := cfg.readFile(p); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.readEnv(); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "mode":
+			cfg.Listen.Mode = *mode
+		case "addr":
+			cfg.Listen.Addr = *addr
+		case "redirect-addr":
+			cfg.Listen.RedirectAddr = *redirect
+		case "port":
+			cfg.Listen.Addr = ":" + *port
+		case "tls-cert":
+			cfg.Listen.TLS.CertFile = *cert
+		case "tls-key":
+			cfg.Listen.TLS.KeyFile = *key
+		case "origins":
+			cfg.CORS.Origins = splitList(*origins)
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// readFile overlays a JSON or YAML (by extension) file. Unknown keys are
+// errors so a typo does not silently fall back to a default.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		doc, err := parseYAML(data)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			line, col := byteOffsetToLineCol(data, int(syntax.Offset))
+			return fmt.Errorf("config file %s:%d:%d: %w", path, line, col, err)
+		}
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// readEnv overlays every setting whose env variable is set.
+func (c *Config) readEnv() error {
+	var problems []string
+	eachSetting(c, func(_ string, f reflect.StructField, v reflect.Value) {
+		name := f.Tag.Get("env")
+		raw, ok := os.LookupEnv(name)
+		if !ok || strings.TrimSpace(raw) == "" {
+			return
+		}
+		if err := setFromString(v, strings.TrimSpace(raw)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s=%q: %v", name, raw, err))
+		}
+	})
+	if _, set := os.LookupEnv("LISTEN_ADDR"); !set {
+		if port := strings.TrimSpace(os.Getenv("PORT")); port != "" {
+			c.Listen.Addr = ":" + port
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid environment:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// Validate checks every setting and reports all problems at once.
+func (c *Config) Validate() error {
+	var problems []string
+	bad := func(key, format string, args ...any) {
+		problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	checkAddr := func(key, addr string) {
+		if _, port, err := net.SplitHostPort(addr); err != nil {
+			bad(key, "%v", err)
+		} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			bad(key, "bad port %q", port)
+		}
+	}
+	checkAddr("listen.addr", c.Listen.Addr)
+	switch c.Listen.Mode {
+	case ListenTLS:
+		for key, file := range map[string]string{"listen.tls.cert_file": c.Listen.TLS.CertFile, "listen.tls.key_file": c.Listen.TLS.KeyFile} {
+			if file == "" {
+				bad(key, "required in tls mode")
+			} else if _, err := os.Stat(file); err != nil {
+				bad(key, "%v", err)
+			}
+		}
+		if c.Listen.RedirectAddr != "" {
+			checkAddr("listen.redirect_addr", c.Listen.RedirectAddr)
+			if c.Listen.RedirectAddr == c.Listen.Addr {
+				bad("listen.redirect_addr", "must differ from listen.addr")
+			}
+		}
+	case ListenHTTP:
+		if c.Listen.RedirectAddr != "" {
+			bad("listen.redirect_addr", "only used in tls mode")
+		}
+	default:
+		bad("listen.mode", "%q is not tls or http", c.Listen.Mode)
+	}
+	for _, p := range c.Listen.TrustedProxies {
+		if _, err := parseTrustedProxy(p); err != nil {
+			bad("listen.trusted_proxies", "%q is not an IP or CIDR", p)
+		}
+	}
+
+	for _, o := range c.CORS.Origins {
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			bad("cors.origins", "%q is not an origin like https://example.com", o)
+		}
+	}
+
+	providers := []string{"openai", "local", "fake", "fixture"}
+	if !slices.Contains(providers, c.Models.Provider) {
+		bad("models.provider", "%q is not one of %s", c.Models.Provider, strings.Join(providers, ", "))
+	}
+	for key, p := range map[string]string{"models.advisor_provider": c.Models.AdvisorProvider, "models.details_provider": c.Models.DetailsProvider} {
+		if p != "" && !slices.Contains(providers, p) {
+			bad(key, "%q is not empty or one of %s", p, strings.Join(providers, ", "))
+		}
+	}
+	if strings.TrimSpace(c.Models.Default) == "" {
+		bad("models.default", "required")
+	}
+	if u, err := url.Parse(c.Models.LocalBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		bad("models.local_base_url", "%q is not an http(s) URL", c.Models.LocalBaseURL)
+	}
+	if strings.TrimSpace(c.Models.Fixture.Dir) == "" {
+		bad("models.fixture.dir", "required")
+	}
+	switch c.Models.Fixture.Mode {
+	case "replay", "record":
+	default:
+		bad("models.fixture.mode", "%q is not replay or record", c.Models.Fixture.Mode)
+	}
+	if src := c.Models.Fixture.Source; src == "fixture" || !slices.Contains(providers, src) {
+		bad("models.fixture.source", "%q is not one of openai, local, fake", src)
+	}
+
+	for key, d := range map[string]Duration{
+		"timeouts.advisor":     c.Timeouts.Advisor,
+		"timeouts.details":     c.Timeouts.Details,
+		"timeouts.read_header": c.Timeouts.ReadHeader,
+		"timeouts.idle":        c.Timeouts.Idle,
+		"timeouts.shutdown":    c.Timeouts.Shutdown,
+		"cache.responses_ttl":  c.Cache.ResponsesTTL,
+		"cache.details_ttl":    c.Cache.DetailsTTL,
+	} {
+		if d <= 0 {
+			bad(key, "must be positive, got %s", d)
+		}
+	}
+	if c.Secrets.ReloadInterval < 0 {
+		bad("secrets.reload_interval", "must not be negative")
+	}
+	if c.Limits.PlanUsagePeriod < 0 {
+		bad("limits.plan_usage_period", "must not be negative")
+	}
+	if c.Limits.BreakerCooldown <= 0 {
+		bad("limits.breaker_cooldown", "must be positive, got %s", c.Limits.BreakerCooldown)
+	}
+	if c.Latency.Window <= 0 {
+		bad("latency.window", "must be positive, got %s", c.Latency.Window)
+	}
+	if path := strings.TrimSpace(c.Limits.PricesFile); path != "" {
+		if _, err := readPriceTable(path); err != nil {
+			bad("limits.prices_file", "%v", err)
+		}
+	}
+	if path := strings.TrimSpace(c.Members.JWKSFile); path != "" {
+		if _, err := loadJWKS(path); err != nil {
+			bad("members.jwks_file", "%v", err)
+		}
+	}
+	if _, err := parseLogLevel(c.Logging.Level); err != nil {
+		bad("logging.level", "%v", err)
+	}
+	switch strings.ToLower(c.Logging.Format) {
+	case "text", "json":
+	default:
+		bad("logging.format", "%q is not text or json", c.Logging.Format)
+	}
+	switch strings.ToLower(c.Tracing.Exporter) {
+	case "none", "stdout", "console", "otlp":
+	default:
+		bad("tracing.exporter", "%q is not none, stdout, console or otlp", c.Tracing.Exporter)
+	}
+	for _, kv := range splitList(c.Tracing.Headers) {
+		if k, _, ok := strings.Cut(kv, "="); !ok || strings.TrimSpace(k) == "" {
+			bad("tracing.headers", "want key=value pairs separated by commas")
+			break
+		}
+	}
+	for key, dir := range map[string]string{"cache.responses_dir": c.Cache.ResponsesDir, "cache.details_dir": c.Cache.DetailsDir} {
+		if strings.TrimSpace(dir) == "" {
+			bad(key, "required")
+		}
+	}
+
+	switch c.Jobs.Store {
+	case "file":
+		if strings.TrimSpace(c.Jobs.StoreDir) == "" {
+			bad("jobs.store_dir", "required when jobs.store is file")
+		}
+	case "memory":
+	default:
+		bad("jobs.store", "%q is not file or memory", c.Jobs.Store)
+	}
+
+	l := c.Limits
+	atLeast := map[string][2]int{
+		"jobs.advisor_workers":     {c.Jobs.AdvisorWorkers, 1},
+		"jobs.advisor_queue_depth": {c.Jobs.AdvisorQueueDepth, 1},
+		"jobs.details_workers":     {c.Jobs.DetailsWorkers, 1},
+		"jobs.details_queue_depth": {c.Jobs.DetailsQueueDepth, 1},
+		"limits.llm_max_attempts":  {l.LLMMaxAttempts, 1},
+		"limits.breaker_threshold": {l.BreakerThreshold, 1},
+		"latency.window_samples":   {c.Latency.WindowSamples, 1},
+		"limits.free_max_schools":  {l.FreeMaxSchools, unlimited},
+		"limits.free_max_runs":     {l.FreeMaxRuns, unlimited},
+		"limits.free_max_details":  {l.FreeMaxDetails, unlimited},
+		"limits.pro_max_schools":   {l.ProMaxSchools, unlimited},
+		"limits.pro_max_runs":      {l.ProMaxRuns, unlimited},
+		"limits.pro_max_details":   {l.ProMaxDetails, unlimited},
+	}
+	for key, v := range atLeast {
+		if v[0] < v[1] {
+			bad(key, "must be at least %d, got %d", v[1], v[0])
+		}
+	}
+	for key, v := range map[string]string{"limits.rate_advisor": l.RateAdvisor, "limits.rate_details": l.RateDetails, "limits.rate_poll": l.RatePoll} {
+		if _, err := parseRateBudget(v); err != nil {
+			bad(key, "%q: %v", v, err)
+		}
+	}
+	for key, v := range map[string]float64{
+		"limits.budget_daily_usd":        l.BudgetDailyUSD,
+		"limits.budget_monthly_usd":      l.BudgetMonthlyUSD,
+		"limits.free_budget_daily_usd":   l.FreeBudgetDailyUSD,
+		"limits.free_budget_monthly_usd": l.FreeBudgetMonthlyUSD,
+		"limits.pro_budget_daily_usd":    l.ProBudgetDailyUSD,
+		"limits.pro_budget_monthly_usd":  l.ProBudgetMonthlyUSD,
+	} {
+		if v < 0 {
+			bad(key, "must not be negative")
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	slices.Sort(problems)
+	return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+}
+
+// Apply makes a validated configuration current by passing each section to
+// the subsystem that reads it. Call it once at startup, before serving
+// requests; the job store is opened afterwards with OpenJobStore(c.Jobs).
+func (c *Config) Apply() {
+	configureLogging(c.Logging)
+	configureTrustedProxies(c.Listen.TrustedProxies)
+	configureLLM(c.Models, c.Limits.LLMMaxAttempts)
+	configureJobQueues(c.Jobs)
+	configurePlans(c.Limits)
+	configureRateLimits(c.Limits)
+	configureBudgets(c.Limits)
+	configureSecrets(c.Secrets)
+	configureMembers(c.Members)
+	configureLatency(c.Latency)
+	configureTracing(c.Tracing)
+
+	responseCache = cacheLocation{Dir: c.Cache.ResponsesDir, TTL: time.Duration(c.Cache.ResponsesTTL)}
+	detailsCache = cacheLocation{Dir: c.Cache.DetailsDir, TTL: time.Duration(c.Cache.DetailsTTL)}
+	advisorJobTimeout = time.Duration(c.Timeouts.Advisor)
+	detailsJobTimeout = time.Duration(c.Timeouts.Details)
+}
+
+// Redacted renders the configuration as one line of JSON with secrets
+// replaced, for the startup log.
+func (c *Config) Redacted() string {
+	out := map[string]any{}
+	eachSetting(c, func(key string, f reflect.StructField, v reflect.Value) {
+		section, name, _ := strings.Cut(key, ".")
+		m, _ := out[section].(map[string]any)
+		if m == nil {
+			m = map[string]any{}
+			out[section] = m
+		}
+		val := v.Interface()
+		if f.Tag.Get("secret") == "true" && v.String() != "" {
+			val = redacted
+		}
+		m[name] = val
+	})
+	b, _ := json.Marshal(out)
+	return string(b)
+}
+
+// eachSetting calls fn for every env-tagged field of c with its dotted JSON
+// key, e.g. "listen.tls.cert_file".
+func eachSetting(c *Config, fn func(key string, f reflect.StructField, v reflect.Value)) {
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			key := name
+			if prefix != "" {
+				key = prefix + "." + name
+			}
+			if f.Tag.Get("env") != "" {
+				fn(key, f, v.Field(i))
+			} else if f.Type.Kind() == reflect.Struct {
+				walk(key, v.Field(i))
+			}
+		}
+	}
+	walk("", reflect.ValueOf(c).Elem())
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+func setFromString(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.New("not a whole number")
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return errors.New("not a number")
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		v.Set(reflect.ValueOf(splitList(s)))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping blanks.
+func splitList(s string) []string {
+	out := []string{}
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
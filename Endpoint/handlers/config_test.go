@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig returns the defaults in http mode, which validate without
+// certificate files.
+func testConfig() Config {
+	c := DefaultConfig()
+	c.Listen.Mode = ListenHTTP
+	c.Listen.Addr = ":8080"
+	return c
+}
+
+func TestConfigValidate(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for _, f := range []string{cert, key} {
+		if err := os.WriteFile(f, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prices := filepath.Join(dir, "prices.json")
+	if err := os.WriteFile(prices, []byte(`{"gpt-5":{"input_per_mtok":1,"output_per_mtok":8}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string // keys expected in the error; none = valid
+	}{
+		{"defaults in http mode", func(c *Config) {}, nil},
+		{"tls with files", func(c *Config) {
+			c.Listen.Mode, c.Listen.TLS.CertFile, c.Listen.TLS.KeyFile = ListenTLS, cert, key
+			c.Listen.RedirectAddr = ":8081"
+		}, nil},
+		{"tls without files", func(c *Config) {
+			c.Listen.Mode, c.Listen.TLS.CertFile, c.Listen.TLS.KeyFile = ListenTLS, "", filepath.Join(dir, "missing.pem")
+		}, []string{"listen.tls.cert_file", "listen.tls.key_file"}},
+		{"redirect on the same address", func(c *Config) {
+			c.Listen.Mode, c.Listen.TLS.CertFile, c.Listen.TLS.KeyFile = ListenTLS, cert, key
+			c.Listen.RedirectAddr = c.Listen.Addr
+		}, []string{"listen.redirect_addr"}},
+		{"redirect in http mode", func(c *Config) { c.Listen.RedirectAddr = ":80" }, []string{"listen.redirect_addr"}},
+		{"bad mode", func(c *Config) { c.Listen.Mode = "https" }, []string{"listen.mode"}},
+		{"bad addr", func(c *Config) { c.Listen.Addr = "8080" }, []string{"listen.addr"}},
+		{"bad port", func(c *Config) { c.Listen.Addr = ":99999" }, []string{"listen.addr"}},
+		{"trusted proxies", func(c *Config) { c.Listen.TrustedProxies = []string{"10.0.0.0/8", "127.0.0.1"} }, nil},
+		{"bad trusted proxy", func(c *Config) { c.Listen.TrustedProxies = []string{"proxy.local"} }, []string{"listen.trusted_proxies"}},
+		{"bad origin", func(c *Config) {
+			c.CORS.Origins = []string{"https://ok.example", "example.com", "https://a.example/path"}
+		}, []string{"cors.origins", "cors.origins"}},
+		{"bad provider", func(c *Config) { c.Models.Provider = "anthropic" }, []string{"models.provider"}},
+		{"no default model", func(c *Config) { c.Models.Default = " " }, []string{"models.default"}},
+		{"per-endpoint providers", func(c *Config) { c.Models.AdvisorProvider, c.Models.DetailsProvider = "fixture", "local" }, nil},
+		{"bad per-endpoint provider", func(c *Config) { c.Models.DetailsProvider = "ollama" }, []string{"models.details_provider"}},
+		{"bad local base url", func(c *Config) { c.Models.LocalBaseURL = "localhost:11434" }, []string{"models.local_base_url"}},
+		{"bad fixture settings", func(c *Config) {
+			c.Models.Fixture.Dir, c.Models.Fixture.Mode, c.Models.Fixture.Source = "", "capture", "fixture"
+		}, []string{"models.fixture.dir", "models.fixture.mode", "models.fixture.source"}},
+		{"zero timeout", func(c *Config) { c.Timeouts.Advisor = 0 }, []string{"timeouts.advisor"}},
+		{"negative reload interval", func(c *Config) { c.Secrets.ReloadInterval = Duration(-time.Second) }, []string{"secrets.reload_interval"}},
+		{"log settings any case", func(c *Config) { c.Logging.Level, c.Logging.Format = "DEBUG", "JSON" }, nil},
+		{"bad log settings", func(c *Config) { c.Logging.Level, c.Logging.Format = "verbose", "xml" }, []string{"logging.format", "logging.level"}},
+		{"otlp with headers", func(c *Config) { c.Tracing.Exporter, c.Tracing.Headers = "otlp", "api-key=abc, x-team=1" }, nil},
+		{"bad tracing", func(c *Config) { c.Tracing.Exporter, c.Tracing.Headers = "jaeger", "api-key" }, []string{"tracing.exporter", "tracing.headers"}},
+		{"empty cache dir", func(c *Config) { c.Cache.DetailsDir = "" }, []string{"cache.details_dir"}},
+		{"memory store", func(c *Config) { c.Jobs.Store, c.Jobs.StoreDir = "memory", "" }, nil},
+		{"file store without dir", func(c *Config) { c.Jobs.Store, c.Jobs.StoreDir = "file", "" }, []string{"jobs.store_dir"}},
+		{"bad store", func(c *Config) { c.Jobs.Store = "redis" }, []string{"jobs.store"}},
+		{"no workers", func(c *Config) { c.Jobs.AdvisorWorkers = 0 }, []string{"jobs.advisor_workers"}},
+		{"unlimited plan", func(c *Config) { c.Limits.ProMaxRuns = unlimited }, nil},
+		{"below unlimited", func(c *Config) { c.Limits.FreeMaxRuns = -2 }, []string{"limits.free_max_runs"}},
+		{"bad rate", func(c *Config) { c.Limits.RatePoll = "100" }, []string{"limits.rate_poll"}},
+		{"bad breaker", func(c *Config) { c.Limits.BreakerThreshold, c.Limits.BreakerCooldown = 0, 0 }, []string{"limits.breaker_cooldown", "limits.breaker_threshold"}},
+		{"missing prices file", func(c *Config) { c.Limits.PricesFile = filepath.Join(dir, "no-prices.json") }, []string{"limits.prices_file"}},
+		{"prices file", func(c *Config) { c.Limits.PricesFile = prices }, nil},
+		{"bad prices file", func(c *Config) { c.Limits.PricesFile = cert }, []string{"limits.prices_file"}},
+		{"missing jwks file", func(c *Config) { c.Members.JWKSFile = filepath.Join(dir, "jwks.json") }, []string{"members.jwks_file"}},
+		{"bad latency window", func(c *Config) { c.Latency.Window, c.Latency.WindowSamples = 0, 0 }, []string{"latency.window", "latency.window_samples"}},
+		{"negative budget", func(c *Config) { c.Limits.ProBudgetDailyUSD = -1 }, []string{"limits.pro_budget_daily_usd"}},
+		{"several problems", func(c *Config) {
+			c.Listen.Mode = "x"
+			c.Limits.LLMMaxAttempts = 0
+			c.Models.Provider = ""
+		}, []string{"limits.llm_max_attempts", "listen.mode", "models.provider"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			tt.modify(&c)
+			err := c.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want problems with %v", tt.want)
+			}
+			lines := strings.Split(err.Error(), "\n  - ")[1:]
+			if len(lines) != len(tt.want) {
+				t.Fatalf("Validate() reported %d problem(s), want %d:\n%v", len(lines), len(tt.want), err)
+			}
+			for i, key := range tt.want {
+				if !strings.HasPrefix(lines[i], key+":") {
+					t.Errorf("problem %d = %q, want key %s", i, lines[i], key)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	file := `{"listen": {"mode": "http", "addr": ":8000"}, "jobs": {"advisor_workers": 2, "details_workers": 3}}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ADVISOR_WORKERS", "6")
+
+	c, err := LoadConfig([]string{"-config", path, "-port", "9000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen.Mode != ListenHTTP || c.Listen.Addr != ":9000" {
+		t.Errorf("listen = %s %s, want http :9000 (flag over file)", c.Listen.Mode, c.Listen.Addr)
+	}
+	if c.Jobs.AdvisorWorkers != 6 || c.Jobs.DetailsWorkers != 3 {
+		t.Errorf("workers = %d/%d, want 6 (env over file) and 3 (file over default)", c.Jobs.AdvisorWorkers, c.Jobs.DetailsWorkers)
+	}
+}
+
+func TestLoadConfigRedactsSecrets(t *testing.T) {
+	t.Setenv("LLM_LOCAL_API_KEY", "sk-local-123")
+	t.Setenv("MEMBER_PRO_PLANS", "pln_gold, pln_team")
+	t.Setenv("LLM_BREAKER_COOLDOWN", "30s")
+
+	c, err := LoadConfig([]string{"-mode", "http", "-port", "8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Models.LocalAPIKey != "sk-local-123" || len(c.Members.ProPlans) != 2 || c.Limits.BreakerCooldown != Duration(30*time.Second) {
+		t.Errorf("env not applied: local key %q, pro plans %q, cooldown %s", c.Models.LocalAPIKey, c.Members.ProPlans, c.Limits.BreakerCooldown)
+	}
+	if out := c.Redacted(); strings.Contains(out, "sk-local-123") {
+		t.Errorf("Redacted() leaks the local API key: %s", out)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"missing file", []string{"-config", filepath.Join(dir, "nope.json")}, nil, "config file"},
+		{"unknown key", []string{"-config", write("typo.json", `{"listen": {"mdoe": "http"}}`)}, nil, `unknown field "mdoe"`},
+		{"syntax error position", []string{"-config", write("broken.json", "{\n  \"listen\": {,}\n}")}, nil, "broken.json:2:"},
+		{"bad env value", []string{"-mode", "http"}, map[string]string{"ADVISOR_WORKERS": "many"}, "ADVISOR_WORKERS"},
+		{"invalid result", []string{"-mode", "http", "-port", "x"}, nil, "listen.addr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := LoadConfig(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig(%q) error = %v, want it to mention %q", tt.args, err, tt.want)
+			}
+		})
+	}
+}
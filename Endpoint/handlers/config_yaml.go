@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML reads the subset of YAML a config file needs: nested mappings
+// by indentation, scalars (plain, "double" or 'single' quoted), lists as
+// "- item" lines or [a, b], and # comments. Anchors, multi-line strings and
+// flow mappings are not supported.
+func parseYAML(data []byte) (map[string]any, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		text := stripYAMLComment(strings.TrimRight(raw, " \t\r"))
+		if strings.TrimSpace(text) == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimLeft(text, " "), "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		trimmed := strings.TrimLeft(text, " ")
+		lines = append(lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+
+	p := &yamlParser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[p.pos].num)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("line %d: top level must be a mapping", lines[0].num)
+	}
+	return m, nil
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// block parses the mapping or list whose lines sit at indent.
+func (p *yamlParser) block(indent int) (any, error) {
+	if strings.HasPrefix(p.lines[p.pos].text, "-") {
+		return p.list(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		l := p.lines[p.pos]
+		key, rest, ok := strings.Cut(l.text, ":")
+		if !ok || strings.HasPrefix(l.text, "-") {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", l.num)
+		}
+		if rest != "" && rest[0] != ' ' {
+			return nil, fmt.Errorf("line %d: expected a space after ':'", l.num)
+		}
+		key = strings.TrimSpace(key)
+		if uq, err := yamlScalar(key); err == nil {
+			key = fmt.Sprint(uq)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.num, key)
+		}
+		p.pos++
+
+		rest = strings.TrimSpace(rest)
+		switch {
+		case rest != "":
+			v, err := yamlScalar(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", l.num, err)
+			}
+			m[key] = v
+		case p.pos < len(p.lines) && p.lines[p.pos].indent > indent:
+			v, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		case p.pos < len(p.lines) && p.lines[p.pos].indent == indent && strings.HasPrefix(p.lines[p.pos].text, "- "):
+			// A list may sit at its key's own indentation.
+			v, err := p.list(indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		default:
+			m[key] = nil
+		}
+	}
+	return m, nil
+}
+
+func (p *yamlParser) list(indent int) ([]any, error) {
+	out := []any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && strings.HasPrefix(p.lines[p.pos].text, "-") {
+		l := p.lines[p.pos]
+		item := strings.TrimSpace(strings.TrimPrefix(l.text, "-"))
+		if item == "" || (!strings.HasPrefix(l.text, "- ") && l.text != "-") {
+			return nil, fmt.Errorf("line %d: only lists of plain values are supported", l.num)
+		}
+		v, err := yamlScalar(item)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", l.num, err)
+		}
+		out = append(out, v)
+		p.pos++
+	}
+	return out, nil
+}
+
+// yamlScalar converts one plain, quoted or [inline, list] value.
+func yamlScalar(s string) (any, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated list %s", s)
+		}
+		out := []any{}
+		for _, item := range strings.Split(s[1:len(s)-1], ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			v, err := yamlScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case strings.HasPrefix(s, "{"), strings.HasPrefix(s, "&"), strings.HasPrefix(s, "*"), s == "|", s == ">":
+		return nil, fmt.Errorf("unsupported YAML syntax %q", s)
+	}
+
+	switch strings.ToLower(s) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	case "null", "~":
+		return nil, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return s, nil
+}
+
+// stripYAMLComment drops a "#" comment that is not inside quotes.
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return strings.TrimRight(s[:i], " \t")
+		}
+	}
+	return s
+}
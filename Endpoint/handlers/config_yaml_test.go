@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]any
+		wantErr string
+	}{
+		{"empty", "", map[string]any{}, ""},
+		{"comments and document marker", "---\n# top\nkey: value # trailing\n", map[string]any{"key": "value"}, ""},
+		{
+			name: "nested mappings",
+			in:   "listen:\n  mode: http\n  tls:\n    cert_file: /c.pem\njobs:\n  advisor_workers: 4\n",
+			want: map[string]any{
+				"listen": map[string]any{"mode": "http", "tls": map[string]any{"cert_file": "/c.pem"}},
+				"jobs":   map[string]any{"advisor_workers": int64(4)},
+			},
+		},
+		{
+			name: "scalars",
+			in:   "a: 1.5\nb: yes\nc: off\nd: ~\ne: \"x # y\"\nf: 'it''s'\ng: 10m\nh:\n",
+			want: map[string]any{"a": 1.5, "b": true, "c": false, "d": nil, "e": "x # y", "f": "it's", "g": "10m", "h": nil},
+		},
+		{
+			name: "lists",
+			in:   "a:\n  - x\n  - 2\nb:\n- y\nc: [p, \"q\", 3]\nd: []\n",
+			want: map[string]any{"a": []any{"x", int64(2)}, "b": []any{"y"}, "c": []any{"p", "q", int64(3)}, "d": []any{}},
+		},
+		{"quoted key", "\"rate_poll\": 10/1m\n", map[string]any{"rate_poll": "10/1m"}, ""},
+
+		{"tab indentation", "a:\n\tb: 1\n", nil, "line 2: tabs"},
+		{"no space after colon", "a:1\n", nil, "line 1: expected a space"},
+		{"not a mapping line", "a: 1\njust text\n", nil, "line 2: expected \"key: value\""},
+		{"duplicate key", "a: 1\na: 2\n", nil, "line 2: duplicate key"},
+		{"bad dedent", "a:\n    b: 1\n  c: 2\n", nil, "line 3: unexpected indentation"},
+		{"top-level list", "- a\n- b\n", nil, "top level must be a mapping"},
+		{"list item without a space", "a:\n  -b\n", nil, "only lists of plain values"},
+		{"flow mapping", "a: {b: 1}\n", nil, "unsupported YAML syntax"},
+		{"anchor", "a: &x 1\n", nil, "unsupported YAML syntax"},
+		{"block string", "a: |\n", nil, "unsupported YAML syntax"},
+		{"unterminated quote", "a: 'b\n", nil, "unterminated string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.in))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseYAML() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYAML() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadYAMLConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	file := `
+listen:
+  mode: http
+  addr: ":8080"
+  trusted_proxies:
+    - 10.0.0.0/8
+cors:
+  origins: [https://a.example, https://b.example]
+timeouts:
+  advisor: 5m
+limits:
+  pro_max_runs: -1
+  rate_poll: 30/1m
+  budget_daily_usd: 2.5
+logging:
+  format: json
+`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := DefaultConfig()
+	if err := c.readFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultConfig()
+	want.Listen.Mode, want.Listen.Addr = ListenHTTP, ":8080"
+	want.Listen.TrustedProxies = []string{"10.0.0.0/8"}
+	want.CORS.Origins = []string{"https://a.example", "https://b.example"}
+	want.Timeouts.Advisor = Duration(5 * time.Minute)
+	want.Limits.ProMaxRuns = unlimited
+	want.Limits.RatePoll = "30/1m"
+	want.Limits.BudgetDailyUSD = 2.5
+	want.Logging.Format = "json"
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("readFile() =\n  %+v\nwant\n  %+v", c, want)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.yml")
+	if err := os.WriteFile(bad, []byte("listen:\n  mdoe: http\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c = DefaultConfig()
+	if err := c.readFile(bad); err == nil || !strings.Contains(err.Error(), `unknown field "mdoe"`) {
+		t.Errorf("readFile() with a typo = %v, want an unknown field error", err)
+	}
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =====================================================
+//                Readiness and build info
+// =====================================================
+//
+// GET /healthz  liveness: "ok" while the process serves requests
+// GET /readyz   readiness: JSON status of each dependency; 503 when any check
+//               fails (a "degraded" check is reported but does not fail).
+//               Errors and details are only shown with the admin token.
+// GET /version  build version, commit and uptime
+//
+// Version, Commit and BuildTime are set at build time, e.g.
+//
+//	go build -ldflags "-X backend/handlers.Version=1.4.0 -X backend/handlers.Commit=$(git rev-parse HEAD)"
+//
+// Without them the VCS stamp Go embeds in the binary is used, if any.
+
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+var processStart = time.Now()
+
+const (
+	checkOK       = "ok"
+	checkDegraded = "degraded"
+	checkFail     = "fail"
+)
+
+type readyCheck struct {
+	Status string         `json:"status"`
+	Error  string         `json:"error,omitempty"`
+	Detail map[string]any `json:"detail,omitempty"`
+}
+
+// readyCacheTTL is how long probe results are reused, so a load balancer
+// probing every second does not touch the disk on each request.
+const readyCacheTTL = 5 * time.Second
+
+var readyCache struct {
+	mu     sync.Mutex
+	at     time.Time
+	checks map[string]readyCheck
+	last   map[string]string // status per check, to log only changes
+}
+
+// Readyz reports whether this instance should receive traffic.
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := readyChecks()
+	// draining is never cached so shutdown takes effect on the next probe
+	checks["draining"] = checkDraining()
+
+	status, code := "ready", http.StatusOK
+	for name, c := range checks {
+		if c.Status == checkFail {
+			status, code = "not_ready", http.StatusServiceUnavailable
+		}
+		if !isAdmin(r) {
+			checks[name] = readyCheck{Status: c.Status}
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, code, map[string]any{
+		"status": status,
+		"checks": checks,
+		"time":   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// readyChecks returns a copy of the dependency checks, re-running them at
+// most once per readyCacheTTL.
+func readyChecks() map[string]readyCheck {
+	readyCache.mu.Lock()
+	defer readyCache.mu.Unlock()
+
+	if readyCache.checks == nil || time.Since(readyCache.at) >= readyCacheTTL {
+		checks := map[string]readyCheck{
+			"api_key":    checkAPIKey(),
+			"cache_dirs": checkCacheDirs(),
+			"job_store":  checkJobStore(),
+			"queues":     checkQueues(),
+			"llm":        checkLLM(),
+		}
+		if readyCache.last == nil {
+			readyCache.last = make(map[string]string)
+		}
+		for name, c := range checks {
+			prev := readyCache.last[name]
+			switch {
+			case c.Status == prev:
+			case c.Status == checkOK:
+				if prev != "" {
+					logInfo("Readyz", "Check status changed", slog.String("check", name), slog.String("status", c.Status), logOK)
+				}
+			default:
+				logWarn("Readyz", "Check status changed",
+					slog.String("check", name), slog.String("status", c.Status), slog.String("error", c.Error), logFail)
+			}
+			readyCache.last[name] = c.Status
+		}
+		readyCache.checks, readyCache.at = checks, time.Now()
+	}
+	return maps.Clone(readyCache.checks)
+}
+
+func checkDraining() readyCheck {
+	if isDraining() {
+		return readyCheck{Status: checkFail, Error: "shutting down"}
+	}
+	return readyCheck{Status: checkOK}
+}
+
+// checkAPIKey reports whether the OpenAI key is loaded, or could be, if a
+// configured provider needs one. It reads the key without loading it, so
+// probes do not trigger reloads or log missing-key errors.
+func checkAPIKey() readyCheck {
+	var users []string
+	for _, kind := range []string{llmKindAdvisor, llmKindDetails} {
+		if llmNeedsAPIKey(kind) {
+			users = append(users, kind)
+		}
+	}
+	if len(users) == 0 {
+		return readyCheck{Status: checkOK, Detail: map[string]any{"required": false}}
+	}
+
+	detail := map[string]any{"required": true, "used_by": users}
+	secrets.mu.RLock()
+	loaded, source := secrets.ok, secrets.source
+	secrets.mu.RUnlock()
+	if !loaded {
+		_, src, _, err := readSecrets()
+		if err != nil {
+			return readyCheck{Status: checkFail, Error: "no OpenAI key could be loaded", Detail: detail}
+		}
+		source = src
+	}
+	detail["source"] = source
+	return readyCheck{Status: checkOK, Detail: detail}
+}
+
+// llmNeedsAPIKey reports whether kind's provider calls OpenAI with our key.
+func llmNeedsAPIKey(kind string) bool {
+	switch llmProviderName(kind) {
+	case "openai":
+		return true
+	case "fixture":
+		fx := llmModels.Fixture
+		if !strings.EqualFold(strings.TrimSpace(fx.Mode), "record") {
+			return false
+		}
+		source := strings.ToLower(strings.TrimSpace(fx.Source))
+		return source == "" || source == "openai"
+	}
+	return false
+}
+
+func checkCacheDirs() readyCheck {
+	c := readyCheck{Status: checkOK, Detail: map[string]any{}}
+	var failed []string
+	for name, loc := range adminCaches {
+		if err := probeWritable(loc.Dir); err != nil {
+			c.Detail[name] = err.Error()
+			failed = append(failed, name)
+			continue
+		}
+		c.Detail[name] = loc.Dir
+	}
+	if len(failed) > 0 {
+		slices.Sort(failed)
+		c.Status = checkFail
+		c.Error = "not writable: " + strings.Join(failed, ", ")
+	}
+	return c
+}
+
+func checkJobStore() readyCheck {
+	if err := jobs.Ping(); err != nil {
+		return readyCheck{Status: checkFail, Error: err.Error()}
+	}
+	return readyCheck{Status: checkOK}
+}
+
+// checkQueues is degraded when a queue is full. New jobs are refused with 503
+// until it drains, but cached results and polls still work, and taking the
+// instance out of rotation would only push its backlog onto the others.
+func checkQueues() readyCheck {
+	startJobQueues()
+	c := readyCheck{Status: checkOK, Detail: map[string]any{}}
+	var full []string
+	for kind, q := range jobQueues {
+		pending, running := q.stats()
+		c.Detail[string(kind)] = map[string]any{
+			"pending":    pending,
+			"depth":      q.depth,
+			"running":    running,
+			"workers":    q.workers,
+			"saturation": float64(pending) / float64(q.depth),
+		}
+		if pending >= q.depth {
+			full = append(full, string(kind))
+		}
+	}
+	if len(full) > 0 {
+		slices.Sort(full)
+		c.Status = checkDegraded
+		c.Error = "queue full: " + strings.Join(full, ", ")
+	}
+	return c
+}
+
+// checkLLM reports the circuit breaker and when an LLM call last succeeded.
+// An open breaker is degraded rather than failing: it is shared upstream
+// trouble that another instance would see too, and cached results still work.
+func checkLLM() readyCheck {
+	state, failures, lastOK := llmBreaker.status()
+	c := readyCheck{Status: checkOK, Detail: map[string]any{
+		"breaker":  state.String(),
+		"failures": failures,
+		"providers": map[string]string{
+			llmKindAdvisor: llmProviderName(llmKindAdvisor),
+			llmKindDetails: llmProviderName(llmKindDetails),
+		},
+	}}
+	if lastOK.IsZero() {
+		c.Detail["last_success"] = nil
+	} else {
+		c.Detail["last_success"] = lastOK.UTC().Format(time.RFC3339)
+		c.Detail["last_success_age_seconds"] = int(time.Since(lastOK).Seconds())
+	}
+	if state == breakerOpen {
+		c.Status = checkDegraded
+		c.Error = fmt.Sprintf("circuit breaker open, retry in %ds", ceilSeconds(llmBreaker.retryAfter()))
+	}
+	return c
+}
+
+// probeWritable creates and removes a file in dir.
+func probeWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// VersionInfo reports build and runtime information.
+func VersionInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildInfo())
+}
+
+func buildInfo() map[string]any {
+	info := map[string]any{
+		"version":        Version,
+		"commit":         Commit,
+		"build_time":     BuildTime,
+		"go_version":     runtime.Version(),
+		"started_at":     processStart.UTC().Format(time.RFC3339),
+		"uptime_seconds": int(time.Since(processStart).Seconds()),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if Commit == "" {
+					info["commit"] = s.Value
+				}
+			case "vcs.time":
+				if BuildTime == "" {
+					info["build_time"] = s.Value
+				}
+			case "vcs.modified":
+				info["dirty"] = s.Value == "true"
+			}
+		}
+	}
+	return info
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// =====================================================
+//                       Job store
+// =====================================================
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// Final reports whether the job will not change state again.
+func (s JobState) Final() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCancelled
+}
+
+type JobKind string
+
+const (
+	JobKindAdvisor JobKind = llmKindAdvisor
+	JobKindDetails JobKind = llmKindDetails
+)
+
+// Job is one background LLM run for /CollegeAdvisor or /CollegeAdvisorDetails.
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        JobKind         `json:"kind"`
+	State       JobState        `json:"state"`
+	Key         string          `json:"key,omitempty"`          // payload checksum (advisor) or school slug (details)
+	Plan        Plan            `json:"plan,omitempty"`         // submitter's plan, for per-plan spend budgets
+	Member      string          `json:"member,omitempty"`       // submitter's usage key, for per-member accounting
+	Charged     bool            `json:"charged,omitempty"`      // the submission used up a plan allowance, given back on failure
+	RequestID   string          `json:"request_id,omitempty"`   // request that created the job, for log correlation
+	TraceParent string          `json:"trace_parent,omitempty"` // span of the request that created the job
+	CreatedAt   time.Time       `json:"created_at"`
+	StartedAt   time.Time       `json:"started_at,omitzero"`
+	FinishedAt  time.Time       `json:"finished_at,omitzero"`
+	Attempts    int             `json:"attempts"`
+	Input       json.RawMessage `json:"input,omitempty"` // original request, used to resume after restart
+	Result      json.RawMessage `json:"result,omitempty"`
+	Partial     []AdvisorSchool `json:"partial,omitempty"` // advisor schools parsed so far while running
+	Error       string          `json:"error,omitempty"`   // user-facing message
+	Model       string          `json:"model,omitempty"`   // model that served the last LLM attempt
+	Usage       LLMUsage        `json:"usage,omitzero"`    // tokens across all attempts
+	CostUSD     float64         `json:"cost_usd,omitempty"`
+}
+
+// jobRetention is how long a finished job is kept after it finishes, so
+// clients can poll again and operators can inspect it in /admin/jobs.
+const jobRetention = 10 * time.Minute
+
+// How long a job's LLM call may run before it is abandoned. Set from the
+// config at startup.
+var (
+	advisorJobTimeout = 10 * time.Minute
+	detailsJobTimeout = 3 * time.Minute
+)
+
+// JobStore holds jobs by ID. Get and Update return copies so callers never
+// share a *Job with the store.
+type JobStore interface {
+	Create(job Job) error
+	Get(id string) (Job, bool)
+	Update(id string, fn func(*Job)) (Job, bool)
+	Delete(id string)
+	List() []Job
+	Ping() error // reports whether the store can take writes
+}
+
+// memJobStore keeps jobs in process memory only.
+type memJobStore struct {
+	mu sync.RWMutex
+	m  map[string]*Job
+}
+
+func newMemJobStore() *memJobStore {
+	return &memJobStore{m: make(map[string]*Job)}
+}
+
+// jobs is the active store; OpenJobStore replaces it at startup.
+var jobs JobStore = newMemJobStore()
+
+func (s *memJobStore) Ping() error { return nil }
+
+func (s *memJobStore) Create(job Job) error {
+	s.mu.Lock()
+	s.m[job.ID] = &job
+	s.mu.Unlock()
+	return nil
+}
+
+// Get returns a copy of the job.
+func (s *memJobStore) Get(id string) (Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	j, ok := s.m[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// Update applies fn to the stored job under the lock and returns the result.
+func (s *memJobStore) Update(id string, fn func(*Job)) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.m[id]
+	if !ok {
+		return Job{}, false
+	}
+	fn(j)
+	return *j, true
+}
+
+func (s *memJobStore) Delete(id string) {
+	s.mu.Lock()
+	delete(s.m, id)
+	s.mu.Unlock()
+}
+
+func (s *memJobStore) List() []Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Job, 0, len(s.m))
+	for _, j := range s.m {
+		out = append(out, *j)
+	}
+	return out
+}
+
+// ---- State transitions ----
+
+func newJob(id string, kind JobKind, key string, input any) Job {
+	raw, _ := json.Marshal(input)
+	return Job{
+		ID:        id,
+		Kind:      kind,
+		State:     JobQueued,
+		Key:       key,
+		CreatedAt: time.Now(),
+		Input:     raw,
+	}
+}
+
+func startJob(id string) {
+	jobs.Update(id, func(j *Job) {
+		if j.State.Final() {
+			return // cancelled between dequeue and start
+		}
+		j.State = JobRunning
+		j.StartedAt = time.Now()
+	})
+	notifyJob(id)
+}
+
+// setJobPartial publishes the schools streamed so far for a running job.
+func setJobPartial(id string, schools []AdvisorSchool) {
+	jobs.Update(id, func(j *Job) {
+		if j.State == JobRunning {
+			j.Partial = schools
+		}
+	})
+	notifyJob(id)
+}
+
+// cancelledJobMessage is the user-facing error for a cancelled job.
+const cancelledJobMessage = "Request cancelled."
+
+// cancelJob marks a queued or running job cancelled, stops its worker and
+// gives back the plan allowance it used. It reports false if the job does
+// not exist or had already finished; the check happens under the store lock,
+// so a result saved concurrently by the worker is never overwritten.
+func cancelJob(id string) bool {
+	job, cancelled := finishJob(id, func(j *Job) {
+		j.State = JobCancelled
+		j.Error = cancelledJobMessage
+	})
+	if !cancelled {
+		return false
+	}
+	dequeueJob(job)
+	stopJobWorker(id)
+	releaseJobUsage(job)
+	return true
+}
+
+func succeedJob(id string, result []byte, attempts int) {
+	finishJob(id, func(j *Job) {
+		j.State = JobSucceeded
+		j.Attempts = attempts
+		j.Result = json.RawMessage(result)
+	})
+}
+
+// failJob marks a job failed and gives back the plan allowance it used.
+func failJob(id string, msg string, attempts int) {
+	job, failed := finishJob(id, func(j *Job) {
+		j.State = JobFailed
+		j.Attempts = attempts
+		j.Error = msg
+	})
+	if failed {
+		releaseJobUsage(job)
+	}
+}
+
+// finishJob moves a job to a final state and schedules its removal after
+// jobRetention. Jobs already final are left untouched. It
+// returns the job and whether this call finished it.
+func finishJob(id string, fn func(*Job)) (Job, bool) {
+	finished := false
+	job, _ := jobs.Update(id, func(j *Job) {
+		if j.State.Final() {
+			return
+		}
+		fn(j)
+		j.Partial = nil
+		j.FinishedAt = time.Now()
+		finished = true
+	})
+	if !finished {
+		return job, false
+	}
+	notifyJob(id)
+	scheduleJobCleanup(id, jobRetention)
+	return job, true
+}
+
+func scheduleJobCleanup(id string, after time.Duration) {
+	time.AfterFunc(after, func() {
+		if _, ok := jobs.Get(id); ok {
+			logDebug("finishJob:Cleanup", "Auto-cleanup: deleting finished job", slog.String("job_id", id))
+			jobs.Delete(id)
+		}
+		jobRequestIDs.Delete(id)
+	})
+}
+
+// ---- Change notifications ----
+//
+// Watchers (the SSE stream) are woken whenever a job changes state or queue
+// position; they re-read the job from the store themselves.
+
+var jobWatchers = struct {
+	mu sync.Mutex
+	m  map[string]map[chan struct{}]struct{}
+}{m: make(map[string]map[chan struct{}]struct{})}
+
+// watchJob returns a channel that receives a value after the job changes,
+// and a func to stop watching. Bursts of changes may coalesce into one value.
+func watchJob(id string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	jobWatchers.mu.Lock()
+	if jobWatchers.m[id] == nil {
+		jobWatchers.m[id] = make(map[chan struct{}]struct{})
+	}
+	jobWatchers.m[id][ch] = struct{}{}
+	jobWatchers.mu.Unlock()
+
+	return ch, func() {
+		jobWatchers.mu.Lock()
+		delete(jobWatchers.m[id], ch)
+		if len(jobWatchers.m[id]) == 0 {
+			delete(jobWatchers.m, id)
+		}
+		jobWatchers.mu.Unlock()
+	}
+}
+
+// notifyJob wakes every watcher of the job without blocking.
+func notifyJob(id string) {
+	jobWatchers.mu.Lock()
+	defer jobWatchers.mu.Unlock()
+	for ch := range jobWatchers.m[id] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ---- Worker contexts ----
+//
+// Running jobs register their context's cancel func so cancelJob can stop
+// an in-flight LLM call.
+
+var jobCancels = struct {
+	mu sync.Mutex
+	m  map[string]context.CancelFunc
+}{m: make(map[string]context.CancelFunc)}
+
+// jobContext returns the worker context for a job. The returned cancel func
+// must be called when the worker exits.
+func jobContext(id string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(withJobSpan(context.Background(), id), timeout)
+	jobCancels.mu.Lock()
+	jobCancels.m[id] = cancel
+	jobCancels.mu.Unlock()
+
+	// cancelJob may have run before the func was registered.
+	if job, ok := jobs.Get(id); ok && job.State == JobCancelled {
+		cancel()
+	}
+
+	return ctx, func() {
+		jobCancels.mu.Lock()
+		delete(jobCancels.m, id)
+		jobCancels.mu.Unlock()
+		cancel()
+	}
+}
+
+func stopJobWorker(id string) {
+	jobCancels.mu.Lock()
+	cancel, ok := jobCancels.m[id]
+	jobCancels.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingProvider holds every completion until its context is cancelled,
+// so tests can act on jobs while they run.
+type blockingProvider struct{ started chan struct{} }
+
+func (blockingProvider) Name() string { return "blocking" }
+
+func (p blockingProvider) Complete(ctx context.Context, _ LLMRequest) (LLMResponse, error) {
+	p.started <- struct{}{}
+	<-ctx.Done()
+	return LLMResponse{}, ctx.Err()
+}
+
+// newBlockingServer is newTestServer with advisor jobs that never finish on
+// their own. Jobs still live when the test ends are cancelled.
+func newBlockingServer(t *testing.T) (*httptest.Server, chan struct{}) {
+	t.Helper()
+	srv := newTestServer(t)
+	started := make(chan struct{}, 16)
+	SetLLMProvider(llmKindAdvisor, blockingProvider{started: started})
+	t.Cleanup(func() {
+		SetLLMProvider(llmKindAdvisor, nil)
+		for _, j := range jobs.List() {
+			cancelJob(j.ID)
+		}
+	})
+	return srv, started
+}
+
+// submitAdvisor posts testProfile with gpa changed, so each gpa is a
+// distinct run, optionally superseding an earlier job.
+func submitAdvisor(t *testing.T, srv *httptest.Server, gpa, supersedes string) (int, map[string]any) {
+	t.Helper()
+	body := maps.Clone(testProfile)
+	body["gpa"] = gpa
+	if supersedes != "" {
+		body["supersedes"] = supersedes
+	}
+	var reply map[string]any
+	resp := postJSON(t, srv, "/CollegeAdvisor", body, &reply)
+	return resp.StatusCode, reply
+}
+
+func waitStarted(t *testing.T, started chan struct{}) {
+	t.Helper()
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("job did not start within 10s")
+	}
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	srv, started := newBlockingServer(t)
+
+	status, reply := submitAdvisor(t, srv, "3.1", "")
+	id, _ := reply["id"].(string)
+	if status != http.StatusOK || id == "" {
+		t.Fatalf("submit: status %d, %v", status, reply)
+	}
+	waitStarted(t, started)
+	if j, _ := jobs.Get(id); j.State != JobRunning || !j.Charged {
+		t.Fatalf("job is %s (charged %v), want running and charged", j.State, j.Charged)
+	}
+
+	var cancelled struct {
+		Cancelled bool     `json:"cancelled"`
+		State     JobState `json:"state"`
+	}
+	resp := postJSON(t, srv, "/CollegeCancel", map[string]string{"id": id}, &cancelled)
+	if resp.StatusCode != http.StatusOK || !cancelled.Cancelled || cancelled.State != JobCancelled {
+		t.Fatalf("cancel: status %d, %+v; want 200, cancelled", resp.StatusCode, cancelled)
+	}
+	got := fetchUntilFinal(t, srv, id)
+	if got.State != JobCancelled || !strings.Contains(got.Success, cancelledJobMessage) {
+		t.Errorf("fetch after cancel = %+v", got)
+	}
+
+	// The cancelled run was given back: both Free runs are still there.
+	for _, gpa := range []string{"3.2", "3.3"} {
+		if status, reply := submitAdvisor(t, srv, gpa, ""); status != http.StatusOK {
+			t.Fatalf("run with gpa %s: status %d, %v; want 200", gpa, status, reply)
+		}
+	}
+	if status, reply := submitAdvisor(t, srv, "3.4", ""); status != http.StatusForbidden || reply["code"] != "plan_limit" {
+		t.Errorf("third run: status %d, %v; want 403 plan_limit", status, reply)
+	}
+}
+
+func TestSupersedeCancelsEarlierJob(t *testing.T) {
+	srv, started := newBlockingServer(t)
+
+	_, first := submitAdvisor(t, srv, "3.1", "")
+	firstID, _ := first["id"].(string)
+	waitStarted(t, started)
+
+	status, second := submitAdvisor(t, srv, "3.2", firstID)
+	secondID, _ := second["id"].(string)
+	if status != http.StatusOK || secondID == "" {
+		t.Fatalf("resubmit: status %d, %v", status, second)
+	}
+	if j, _ := jobs.Get(firstID); j.State != JobCancelled {
+		t.Fatalf("superseded job is %s, want cancelled", j.State)
+	}
+
+	// Resubmitting the same payload hands the charge to the new job rather
+	// than giving the run back.
+	status, third := submitAdvisor(t, srv, "3.2", secondID)
+	thirdID, _ := third["id"].(string)
+	if status != http.StatusOK || thirdID == "" {
+		t.Fatalf("same-payload resubmit: status %d, %v", status, third)
+	}
+	if j, _ := jobs.Get(thirdID); !j.Charged {
+		t.Error("same-payload resubmission is not charged for the run")
+	}
+
+	// Only the live run counts: one more Free run fits, a second does not.
+	if status, reply := submitAdvisor(t, srv, "3.3", ""); status != http.StatusOK {
+		t.Fatalf("second run: status %d, %v; want 200", status, reply)
+	}
+	if status, reply := submitAdvisor(t, srv, "3.4", ""); status != http.StatusForbidden || reply["code"] != "plan_limit" {
+		t.Errorf("third run: status %d, %v; want 403 plan_limit", status, reply)
+	}
+}
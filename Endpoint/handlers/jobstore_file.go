@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// =====================================================
+//                 File-backed job store
+// =====================================================
+//
+// fileJobStore keeps the in-memory index of memJobStore and mirrors every job
+// to <dir>/<id>.json, so queued and running jobs resume after a restart and
+// finished ones can still be fetched.
+//
+//	JOB_STORE       file (default) or memory
+//	JOB_STORE_DIR   directory for job files (default data/jobs)
+//
+// Files are written 0600, since a live job's input is the student's profile.
+// Once a job finishes its input is dropped from the file and only the outcome
+// is kept (state, result or error, attempts, usage and cost). The file goes
+// with the job when jobRetention runs out, including after a restart.
+
+const defaultJobStoreDir = "data/jobs"
+
+// storedJob is the on-disk form of a Job. Streamed progress is left out; a
+// resumed job starts over from Input. Usage and cost are kept so the tokens
+// spent before a restart still count against the job.
+type storedJob struct {
+	ID          string          `json:"id"`
+	Kind        JobKind         `json:"kind"`
+	State       JobState        `json:"state"`
+	Key         string          `json:"key,omitempty"`
+	Plan        Plan            `json:"plan,omitempty"`
+	Member      string          `json:"member,omitempty"`
+	Charged     bool            `json:"charged,omitempty"`
+	RequestID   string          `json:"request_id,omitempty"`
+	TraceParent string          `json:"trace_parent,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	StartedAt   time.Time       `json:"started_at,omitzero"`
+	FinishedAt  time.Time       `json:"finished_at,omitzero"`
+	Attempts    int             `json:"attempts"`
+	Input       json.RawMessage `json:"input,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Model       string          `json:"model,omitempty"`
+	Usage       LLMUsage        `json:"usage,omitzero"`
+	CostUSD     float64         `json:"cost_usd,omitempty"`
+}
+
+// toStoredJob drops the input of a finished job; it is no longer needed.
+func toStoredJob(j Job) storedJob {
+	sj := storedJob{
+		ID:          j.ID,
+		Kind:        j.Kind,
+		State:       j.State,
+		Key:         j.Key,
+		Plan:        j.Plan,
+		Member:      j.Member,
+		Charged:     j.Charged,
+		RequestID:   j.RequestID,
+		TraceParent: j.TraceParent,
+		CreatedAt:   j.CreatedAt,
+		StartedAt:   j.StartedAt,
+		FinishedAt:  j.FinishedAt,
+		Attempts:    j.Attempts,
+		Input:       j.Input,
+		Result:      j.Result,
+		Error:       j.Error,
+		Model:       j.Model,
+		Usage:       j.Usage,
+		CostUSD:     j.CostUSD,
+	}
+	if j.State.Final() {
+		sj.Input = nil
+	}
+	return sj
+}
+
+// job rebuilds a Job. One that was queued or running comes back queued, for
+// ResumeJobs to re-queue; a finished one keeps its outcome.
+func (sj storedJob) job() Job {
+	state := sj.State
+	if !state.Final() {
+		state = JobQueued
+	}
+	return Job{
+		ID:          sj.ID,
+		Kind:        sj.Kind,
+		State:       state,
+		Key:         sj.Key,
+		Plan:        sj.Plan,
+		Member:      sj.Member,
+		Charged:     sj.Charged,
+		RequestID:   sj.RequestID,
+		TraceParent: sj.TraceParent,
+		CreatedAt:   sj.CreatedAt,
+		StartedAt:   sj.StartedAt,
+		FinishedAt:  sj.FinishedAt,
+		Attempts:    sj.Attempts,
+		Input:       sj.Input,
+		Result:      sj.Result,
+		Error:       sj.Error,
+		Model:       sj.Model,
+		Usage:       sj.Usage,
+		CostUSD:     sj.CostUSD,
+	}
+}
+
+type fileJobStore struct {
+	*memJobStore
+	dir string
+}
+
+func newFileJobStore(dir string) (*fileJobStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("job store: %w", err)
+	}
+	s := &fileJobStore{memJobStore: newMemJobStore(), dir: dir}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("job store: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		b, err := os.ReadFile(path)
+		if err != nil {
+			logWarn("fileJobStore", "Skipping unreadable job file", slog.String("path", path), slog.Any("error", err))
+			continue
+		}
+		var sj storedJob
+		if err := json.Unmarshal(b, &sj); err != nil || sj.ID == "" || (!sj.State.Final() && len(sj.Input) == 0) {
+			logWarn("fileJobStore", "Removing corrupt job file", slog.String("path", path))
+			s.remove(path)
+			continue
+		}
+		if sj.State.Final() && time.Since(sj.FinishedAt) >= jobRetention {
+			s.remove(path)
+			continue
+		}
+		job := sj.job()
+		s.memJobStore.m[job.ID] = &job
+	}
+	logDebug("fileJobStore", "Loaded jobs", slog.Int("jobs", len(s.memJobStore.m)), slog.String("dir", dir))
+	return s, nil
+}
+
+func (s *fileJobStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *fileJobStore) remove(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logWarn("fileJobStore", "Failed to remove job file", slog.String("path", path), slog.Any("error", err))
+	}
+}
+
+func (s *fileJobStore) persist(job Job) error {
+	data, err := json.MarshalIndent(toStoredJob(job), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(job.ID), data, 0o600)
+}
+
+// Ping checks that the job directory is still writable.
+func (s *fileJobStore) Ping() error {
+	return probeWritable(s.dir)
+}
+
+func (s *fileJobStore) Create(job Job) error {
+	if err := s.persist(job); err != nil {
+		return fmt.Errorf("job store: %w", err)
+	}
+	return s.memJobStore.Create(job)
+}
+
+// Update persists under the store lock so concurrent updates to the same job
+// cannot reach disk out of order.
+func (s *fileJobStore) Update(id string, fn func(*Job)) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.m[id]
+	if !ok {
+		return Job{}, false
+	}
+	fn(j)
+	if err := s.persist(*j); err != nil {
+		logWarn("fileJobStore", "Failed to persist job", slog.String("job_id", id), slog.Any("error", err))
+	}
+	return *j, true
+}
+
+func (s *fileJobStore) Delete(id string) {
+	s.memJobStore.Delete(id)
+	s.remove(s.path(id))
+}
+
+// OpenJobStore selects the job store from the jobs section of the config
+// (JOB_STORE / JOB_STORE_DIR). Call it once at startup, before serving
+// requests.
+func OpenJobStore(c JobsConfig) error {
+	switch kind := strings.ToLower(strings.TrimSpace(c.Store)); kind {
+	case "memory":
+		jobs = newMemJobStore()
+	case "file":
+		s, err := newFileJobStore(c.StoreDir)
+		if err != nil {
+			return err
+		}
+		jobs = s
+	default:
+		return fmt.Errorf("unknown JOB_STORE %q (want file or memory)", kind)
+	}
+	return nil
+}
+
+// ResumeJobs restarts work interrupted by a restart: jobs that were queued or
+// running are re-queued and re-run from their stored input, and finished ones
+// are scheduled for removal when their retention runs out.
+func ResumeJobs() {
+	pending := jobs.List()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt.Before(pending[j].CreatedAt) })
+
+	resumed := 0
+	for _, job := range pending {
+		tagJobRequest(job.ID, job.RequestID)
+		if job.State.Final() {
+			scheduleJobCleanup(job.ID, time.Until(job.FinishedAt.Add(jobRetention)))
+			continue
+		}
+		if _, err := enqueueJob(job, true); err != nil {
+			logError("ResumeJobs", "Cannot resume job",
+				slog.String("job_id", job.ID), slog.String("kind", string(job.Kind)), slog.Any("error", err), logFail)
+			failJob(job.ID, "This request was interrupted. Please try again.", job.Attempts)
+			continue
+		}
+		resumed++
+	}
+	if resumed > 0 {
+		logDebug("ResumeJobs", "Resumed interrupted jobs", slog.Int("jobs", resumed))
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileJobStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newFileJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued := newJob("job-queued", JobKindAdvisor, "k1", testProfile)
+	queued.Member, queued.Plan, queued.Charged = "mem_1", PlanPro, true
+	running := newJob("job-running", JobKindDetails, "", SchoolDetailsRequest{School: "MIT"})
+	done := newJob("job-done", JobKindAdvisor, "k2", testProfile)
+	for _, j := range []Job{queued, running, done} {
+		if err := s.Create(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Update(running.ID, func(j *Job) { j.State, j.StartedAt, j.Attempts = JobRunning, time.Now(), 1 })
+	s.Update(done.ID, func(j *Job) {
+		j.State, j.FinishedAt, j.Attempts = JobSucceeded, time.Now(), 2
+		j.Result = json.RawMessage(`{"schools":[]}`)
+		j.Usage = LLMUsage{PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150}
+		j.CostUSD = 0.25
+	})
+
+	info, err := os.Stat(filepath.Join(dir, done.ID+".json"))
+	if err != nil {
+		t.Fatalf("finished job has no file: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("job file mode = %o, want 600", perm)
+	}
+	b, _ := os.ReadFile(filepath.Join(dir, done.ID+".json"))
+	if strings.Contains(string(b), `"input"`) {
+		t.Errorf("finished job file still holds the input:\n%s", b)
+	}
+
+	reloaded, err := newFileJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(reloaded.List()); got != 3 {
+		t.Fatalf("reloaded %d job(s), want 3", got)
+	}
+	if j, _ := reloaded.Get(queued.ID); j.State != JobQueued || j.Member != "mem_1" || j.Plan != PlanPro || !j.Charged || len(j.Input) == 0 {
+		t.Errorf("queued job = %+v, want it back with owner, plan, charge and input", j)
+	}
+	if j, _ := reloaded.Get(running.ID); j.State != JobQueued || j.Attempts != 1 {
+		t.Errorf("running job came back %s with %d attempt(s), want queued with 1", j.State, j.Attempts)
+	}
+	j, _ := reloaded.Get(done.ID)
+	var result AdvisorResult
+	if err := json.Unmarshal(j.Result, &result); err != nil {
+		t.Errorf("finished job result %q: %v", j.Result, err)
+	}
+	if j.State != JobSucceeded || j.Attempts != 2 ||
+		j.Usage.TotalTokens != 150 || j.CostUSD != 0.25 || j.FinishedAt.IsZero() {
+		t.Errorf("finished job = %+v, want its outcome kept", j)
+	}
+
+	reloaded.Delete(done.ID)
+	if _, err := os.Stat(filepath.Join(dir, done.ID+".json")); !os.IsNotExist(err) {
+		t.Errorf("Delete left the file behind: %v", err)
+	}
+}
+
+func TestFileJobStoreDropsExpiredAndCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, v any) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("expired.json", storedJob{ID: "expired", Kind: JobKindAdvisor, State: JobFailed,
+		FinishedAt: time.Now().Add(-jobRetention - time.Minute), Error: "boom"})
+	write("recent.json", storedJob{ID: "recent", Kind: JobKindAdvisor, State: JobFailed,
+		FinishedAt: time.Now().Add(-time.Minute), Error: "boom"})
+	write("no-input.json", storedJob{ID: "no-input", Kind: JobKindAdvisor, State: JobQueued})
+	if err := os.WriteFile(filepath.Join(dir, "garbage.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newFileJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j, ok := s.Get("recent"); !ok || j.Error != "boom" {
+		t.Errorf("recent failed job = %+v, %v; want it kept with its error", j, ok)
+	}
+	for _, id := range []string{"expired", "no-input", "garbage"} {
+		if _, ok := s.Get(id); ok {
+			t.Errorf("%s was loaded", id)
+		}
+		if _, err := os.Stat(filepath.Join(dir, id+".json")); !os.IsNotExist(err) {
+			t.Errorf("%s.json was not removed", id)
+		}
+	}
+}
+
+func TestResumeJobsAfterRestart(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := JobsConfig{Store: "file", StoreDir: t.TempDir()}
+
+	// Before the restart: one job still queued, one already failed.
+	before, err := newFileJobStore(cfg.StoreDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := newJob("job-interrupted", JobKindAdvisor, "resume-key", testProfile)
+	failed := newJob("job-failed", JobKindAdvisor, "failed-key", testProfile)
+	for _, j := range []Job{queued, failed} {
+		if err := before.Create(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before.Update(failed.ID, func(j *Job) {
+		j.State, j.FinishedAt, j.Error = JobFailed, time.Now(), "The model is unavailable."
+	})
+
+	if err := OpenJobStore(cfg); err != nil {
+		t.Fatal(err)
+	}
+	ResumeJobs()
+
+	got := fetchUntilFinal(t, srv, failed.ID)
+	if got.State != JobFailed || !strings.Contains(got.Success, "The model is unavailable.") {
+		t.Errorf("failed job after restart = %+v, want its error", got)
+	}
+	if got := fetchUntilFinal(t, srv, queued.ID); got.State != JobSucceeded {
+		t.Errorf("resumed job = %+v, want succeeded", got)
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// =====================================================
+//              Rolling latency windows
+// =====================================================
+//
+// Besides the lifetime average, each latencyStats keeps the durations of its
+// most recent successful jobs, tagged with model and school_amount, and saves
+// them in its stats file so a restart does not start cold. Estimates use the
+// most specific bucket that has enough samples:
+//
+//	model+schools -> model -> schools -> all, each first within the last
+//	LATENCY_WINDOW and then across all kept samples.
+//
+//	LATENCY_WINDOW_SAMPLES   samples kept per endpoint (default 500)
+//	LATENCY_WINDOW           time window preferred for estimates (default 24h)
+
+const (
+	defaultLatencyWindowSamples = 500
+	defaultLatencyWindow        = 24 * time.Hour
+
+	// minLatencySamples is the fewest samples a bucket needs to be trusted.
+	minLatencySamples = 5
+
+	// defaultEstimateMs is used before any job has ever finished.
+	defaultEstimateMs = 30_000
+)
+
+type latencySample struct {
+	At      time.Time `json:"at"`
+	Ms      int64     `json:"ms"`
+	Model   string    `json:"model,omitempty"`
+	Schools int       `json:"schools,omitempty"`
+}
+
+// latencySegment selects samples; zero fields match anything.
+type latencySegment struct {
+	Model   string
+	Schools int
+}
+
+func (s latencySegment) matches(x latencySample) bool {
+	return (s.Model == "" || s.Model == x.Model) && (s.Schools == 0 || s.Schools == x.Schools)
+}
+
+func (s latencySegment) String() string {
+	var parts []string
+	if s.Model != "" {
+		parts = append(parts, "model="+s.Model)
+	}
+	if s.Schools != 0 {
+		parts = append(parts, fmt.Sprintf("schools=%d", s.Schools))
+	}
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, ",")
+}
+
+// latencyEstimate summarizes the bucket an estimate was taken from.
+type latencyEstimate struct {
+	Segment string  `json:"segment"` // e.g. "model=gpt-5,schools=5" or "all"
+	Window  string  `json:"window"`  // "24h", "recent", "lifetime" or "default"
+	Samples int     `json:"samples"`
+	P50Ms   float64 `json:"p50_ms"`
+	P90Ms   float64 `json:"p90_ms"`
+	P99Ms   float64 `json:"p99_ms"`
+}
+
+// latencyConfig is the latency section of the config, set by
+// configureLatency.
+var latencyConfig = LatencyConfig{
+	Window:        Duration(defaultLatencyWindow),
+	WindowSamples: defaultLatencyWindowSamples,
+}
+
+// configureLatency sets how many samples are kept and the window estimates
+// prefer.
+func configureLatency(c LatencyConfig) {
+	latencyConfig = c
+}
+
+func latencyWindowSamples() int { return latencyConfig.WindowSamples }
+
+func latencyWindow() time.Duration { return time.Duration(latencyConfig.Window) }
+
+// observe records a finished job's total LLM time in the rolling window.
+func (ls *latencyStats) observe(d time.Duration, model string, schools int) {
+	ls.mu.Lock()
+	ls.Samples = append(ls.Samples, latencySample{At: time.Now(), Ms: d.Milliseconds(), Model: model, Schools: schools})
+	if n := latencyWindowSamples(); len(ls.Samples) > n {
+		ls.Samples = append([]latencySample(nil), ls.Samples[len(ls.Samples)-n:]...)
+	}
+	ls.mu.Unlock()
+
+	ls.save()
+}
+
+// estimate returns percentiles for seg, widening the bucket until one has
+// enough samples, and falling back to the lifetime average.
+func (ls *latencyStats) estimate(seg latencySegment) latencyEstimate {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	since := time.Now().Add(-latencyWindow())
+	candidates := []latencySegment{seg, {Model: seg.Model}, {Schools: seg.Schools}, {}}
+	for _, c := range candidates {
+		for _, recent := range []bool{true, false} {
+			var ms []int64
+			for _, s := range ls.Samples {
+				if c.matches(s) && (!recent || s.At.After(since)) {
+					ms = append(ms, s.Ms)
+				}
+			}
+			if len(ms) < minLatencySamples {
+				continue
+			}
+			window := "recent"
+			if recent {
+				window = shortDuration(latencyWindow())
+			}
+			return percentiles(c, window, ms)
+		}
+	}
+
+	if ls.Count > 0 {
+		return latencyEstimate{Segment: "all", Window: "lifetime", Samples: int(ls.Count), P50Ms: ls.AvgMs, P90Ms: ls.AvgMs, P99Ms: ls.AvgMs}
+	}
+	return latencyEstimate{Segment: "all", Window: "default", P50Ms: defaultEstimateMs, P90Ms: defaultEstimateMs, P99Ms: defaultEstimateMs}
+}
+
+// segments returns an estimate for every model/school_amount pair among the
+// kept samples that has enough samples of its own, for the admin API.
+func (ls *latencyStats) segments() []latencyEstimate {
+	ls.mu.Lock()
+	seen := map[latencySegment]bool{}
+	for _, s := range ls.Samples {
+		seen[latencySegment{Model: s.Model, Schools: s.Schools}] = true
+	}
+	ls.mu.Unlock()
+
+	out := make([]latencyEstimate, 0, len(seen))
+	for seg := range seen {
+		if e := ls.estimate(seg); e.Segment == seg.String() {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Segment < out[j].Segment })
+	return out
+}
+
+// shortDuration formats 24h0m0s as 24h and 1m30s as-is.
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+func percentiles(seg latencySegment, window string, ms []int64) latencyEstimate {
+	sort.Slice(ms, func(i, j int) bool { return ms[i] < ms[j] })
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(ms)))) - 1
+		return float64(ms[max(0, min(i, len(ms)-1))])
+	}
+	return latencyEstimate{
+		Segment: seg.String(),
+		Window:  window,
+		Samples: len(ms),
+		P50Ms:   rank(0.50),
+		P90Ms:   rank(0.90),
+		P99Ms:   rank(0.99),
+	}
+}
+
+// estimateCompletionMs guesses how long until a job at 1-based queue
+// position finishes: its own median run plus the share of the position-1 jobs
+// ahead of it that the workers must get through first.
+func estimateCompletionMs(kind JobKind, seg latencySegment, position int) (float64, latencyEstimate) {
+	latency := AdvisorLatency
+	if kind == JobKindDetails {
+		latency = DetailsLatency
+	}
+	est := latency.estimate(seg)
+	workers := 1
+	if q := queueFor(kind); q != nil && q.workers > 0 {
+		workers = q.workers
+	}
+	ahead := max(position-1, 0)
+	return est.P50Ms * (1 + float64(ahead)/float64(workers)), est
+}
@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+	"github.com/openai/openai-go/v2/shared"
+)
+
+// =====================================================
+//                    LLM providers
+// =====================================================
+
+// Endpoint kinds used to pick a provider and model per endpoint.
+const (
+	llmKindAdvisor = "advisor"
+	llmKindDetails = "details"
+)
+
+// LLMRequest is a single system+user prompt sent to a provider.
+type LLMRequest struct {
+	Kind   string // llmKindAdvisor | llmKindDetails
+	Key    string // cache key: checksumPayload (advisor) or slugify (details)
+	Model  string
+	System string
+	User   string
+	Schema *LLMSchema // optional structured-output response format
+
+	// OnDelta, if set, is called with the reply text accumulated so far as
+	// it streams in. Providers that cannot stream call it once at the end.
+	OnDelta func(text string)
+}
+
+// LLMUsage is the token usage reported for one completion.
+type LLMUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	ReasoningTokens  int64 `json:"reasoning_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+// LLMResponse is the text returned by a provider plus its usage.
+type LLMResponse struct {
+	Text  string
+	Model string
+	Usage LLMUsage
+}
+
+// LLMProvider completes a prompt. Implementations must be safe for concurrent use.
+type LLMProvider interface {
+	Name() string
+	Complete(ctx context.Context, req LLMRequest) (LLMResponse, error)
+}
+
+// ---- Provider registry ----
+//
+// Providers are chosen per endpoint kind from the models section of the
+// config, or these variables:
+//
+//	LLM_PROVIDER            default provider for every kind (openai|local|fake|fixture)
+//	ADVISOR_LLM_PROVIDER    override for /CollegeAdvisor
+//	DETAILS_LLM_PROVIDER    override for /CollegeAdvisorDetails
+//	LLM_MODEL               default model (gpt-5)
+//	ADVISOR_MODEL           model override for /CollegeAdvisor
+//	DETAILS_MODEL           model override for /CollegeAdvisorDetails
+//	LLM_LOCAL_BASE_URL      base URL of an OpenAI-compatible server (local provider)
+//	LLM_LOCAL_API_KEY       optional key for the local server
+
+const defaultLLMModel = openai.ChatModelGPT5
+
+var llmProviders = struct {
+	mu sync.RWMutex
+	m  map[string]LLMProvider
+}{m: make(map[string]LLMProvider)}
+
+// SetLLMProvider overrides the provider used for an endpoint kind.
+// Passing nil restores the configured default on next use.
+func SetLLMProvider(kind string, p LLMProvider) {
+	llmProviders.mu.Lock()
+	defer llmProviders.mu.Unlock()
+	if p == nil {
+		delete(llmProviders.m, kind)
+		return
+	}
+	llmProviders.m[kind] = p
+}
+
+// llmProviderFor returns the provider for kind, building it from the
+// config on first use. Construction errors are not cached so a key
+// added later is picked up.
+func llmProviderFor(kind string) (LLMProvider, error) {
+	llmProviders.mu.RLock()
+	p, ok := llmProviders.m[kind]
+	llmProviders.mu.RUnlock()
+	if ok {
+		return p, nil
+	}
+
+	p, err := newLLMProvider(llmProviderName(kind))
+	if err != nil {
+		return nil, err
+	}
+
+	llmProviders.mu.Lock()
+	defer llmProviders.mu.Unlock()
+	if existing, ok := llmProviders.m[kind]; ok {
+		return existing, nil
+	}
+	llmProviders.m[kind] = p
+	return p, nil
+}
+
+// resetLLMProviders drops cached providers named name so the next call
+// builds them again, e.g. after the API key changed. Overrides installed with
+// SetLLMProvider are dropped too if they carry that name.
+func resetLLMProviders(name string) {
+	llmProviders.mu.Lock()
+	defer llmProviders.mu.Unlock()
+	for kind, p := range llmProviders.m {
+		if p.Name() == name {
+			delete(llmProviders.m, kind)
+		}
+	}
+}
+
+// defaultLocalBaseURL is where the local provider looks for an
+// OpenAI-compatible server (Ollama's default).
+const defaultLocalBaseURL = "http://localhost:11434/v1"
+
+// llmModels is the models section of the config, set by configureLLM.
+var llmModels = ModelsConfig{
+	Provider:     "openai",
+	Default:      defaultLLMModel,
+	LocalBaseURL: defaultLocalBaseURL,
+	Fixture:      FixtureConfig{Dir: defaultFixtureDir, Mode: "replay", Source: "openai"},
+}
+
+// configureLLM sets the providers, models and attempt limit for LLM calls.
+func configureLLM(c ModelsConfig, maxAttempts int) {
+	llmModels = c
+	llmAttempts = maxAttempts
+}
+
+// llmProviderName returns the provider configured for an endpoint kind.
+func llmProviderName(kind string) string {
+	name := llmModels.Provider
+	switch kind {
+	case llmKindAdvisor:
+		name = cmp.Or(llmModels.AdvisorProvider, name)
+	case llmKindDetails:
+		name = cmp.Or(llmModels.DetailsProvider, name)
+	}
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// llmModelFor returns the model configured for an endpoint kind.
+func llmModelFor(kind string) string {
+	model := llmModels.Default
+	switch kind {
+	case llmKindAdvisor:
+		model = cmp.Or(llmModels.Advisor, model)
+	case llmKindDetails:
+		model = cmp.Or(llmModels.Details, model)
+	}
+	return model
+}
+
+func newLLMProvider(name string) (LLMProvider, error) {
+	switch name {
+	case "openai":
+		key, err := getAPIKey()
+		if err != nil {
+			return nil, err
+		}
+		return &openAIProvider{
+			name:   "openai",
+			client: openai.NewClient(option.WithAPIKey(key)),
+		}, nil
+	case "local":
+		base := cmp.Or(strings.TrimSpace(llmModels.LocalBaseURL), defaultLocalBaseURL)
+		// Most compatible servers ignore the key but the client requires one.
+		key := cmp.Or(strings.TrimSpace(llmModels.LocalAPIKey), "local")
+		return &openAIProvider{
+			name:   "local",
+			client: openai.NewClient(option.WithBaseURL(base), option.WithAPIKey(key)),
+		}, nil
+	case "fake":
+		return fakeProvider{}, nil
+	case "fixture":
+		return newFixtureProvider(llmModels.Fixture)
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", name)
+	}
+}
+
+// ---- OpenAI (and OpenAI-compatible) provider ----
+
+type openAIProvider struct {
+	name   string
+	client openai.Client
+}
+
+func (p *openAIProvider) Name() string { return p.name }
+
+func (p *openAIProvider) Complete(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	if req.OnDelta != nil {
+		return p.stream(ctx, req)
+	}
+	resp, err := p.client.Chat.Completions.New(ctx, chatCompletionParams(req))
+	if err != nil {
+		return LLMResponse{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return LLMResponse{}, errors.New("model returned no choices")
+	}
+
+	return LLMResponse{
+		Text:  resp.Choices[0].Message.Content,
+		Model: resp.Model,
+		Usage: LLMUsage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+			ReasoningTokens:  resp.Usage.CompletionTokensDetails.ReasoningTokens,
+			TotalTokens:      resp.Usage.TotalTokens,
+		},
+	}, nil
+}
+
+// stream runs the completion with the streaming API, reporting the
+// accumulated content to req.OnDelta after every chunk.
+func (p *openAIProvider) stream(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	params := chatCompletionParams(req)
+	params.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+
+	stream := p.client.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	var (
+		acc       openai.ChatCompletionAccumulator
+		text      strings.Builder
+		reasoning int64
+	)
+	for stream.Next() {
+		chunk := stream.Current()
+		acc.AddChunk(chunk)
+		reasoning += chunk.Usage.CompletionTokensDetails.ReasoningTokens
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			text.WriteString(chunk.Choices[0].Delta.Content)
+			req.OnDelta(text.String())
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return LLMResponse{}, err
+	}
+	if len(acc.Choices) == 0 {
+		return LLMResponse{}, errors.New("model returned no choices")
+	}
+
+	return LLMResponse{
+		Text:  text.String(),
+		Model: acc.Model,
+		Usage: LLMUsage{
+			PromptTokens:     acc.Usage.PromptTokens,
+			CompletionTokens: acc.Usage.CompletionTokens,
+			ReasoningTokens:  reasoning,
+			TotalTokens:      acc.Usage.TotalTokens,
+		},
+	}, nil
+}
+
+func chatCompletionParams(req LLMRequest) openai.ChatCompletionNewParams {
+	params := openai.ChatCompletionNewParams{
+		Model: req.Model,
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(req.System),
+			openai.UserMessage(req.User),
+		},
+	}
+	if req.Schema != nil {
+		params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
+				JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name:        req.Schema.Name,
+					Description: openai.String(req.Schema.Description),
+					Schema:      req.Schema.Schema,
+					Strict:      openai.Bool(req.Schema.Strict),
+				},
+			},
+		}
+	}
+	return params
+}
+
+// ---- Deterministic fake provider ----
+//
+// fakeProvider never touches the network. The same request always produces
+// the same reply, shaped like the real contract for each endpoint kind.
+
+type fakeProvider struct{}
+
+func (fakeProvider) Name() string { return "fake" }
+
+var (
+	fakeSchoolAmountRegex = regexp.MustCompile(`top (\d+) options`)
+	fakeCollegeRegex      = regexp.MustCompile(`(?m)^College: (.+)$`)
+)
+
+var fakeSchoolNames = []string{
+	"University of Michigan",
+	"University of Washington",
+	"Purdue University",
+	"University of Wisconsin-Madison",
+	"Ohio State University",
+	"University of Minnesota",
+	"Indiana University Bloomington",
+	"Michigan State University",
+	"Arizona State University",
+	"Iowa State University",
+}
+
+func (fakeProvider) Complete(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return LLMResponse{}, err
+	}
+
+	sum := sha256.Sum256([]byte(req.System + "\n" + req.User))
+	seed := binary.BigEndian.Uint64(sum[:8])
+
+	var out any
+	switch req.Kind {
+	case llmKindDetails:
+		out = fakeDetails(req.User)
+	default:
+		out = fakeSchools(req.User, seed)
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return LLMResponse{}, err
+	}
+
+	if req.OnDelta != nil {
+		streamText(string(b), req.OnDelta)
+	}
+
+	prompt := int64(len(req.System)+len(req.User)) / 4
+	completion := int64(len(b)) / 4
+	return LLMResponse{
+		Text:  string(b),
+		Model: "fake",
+		Usage: LLMUsage{
+			PromptTokens:     prompt,
+			CompletionTokens: completion,
+			TotalTokens:      prompt + completion,
+		},
+	}, nil
+}
+
+// streamText replays a finished reply to onDelta in fixed-size pieces so
+// offline providers exercise the same incremental path as a real stream.
+func streamText(text string, onDelta func(string)) {
+	const chunk = 64
+	for end := chunk; end < len(text); end += chunk {
+		onDelta(text[:end])
+	}
+	onDelta(text)
+}
+
+func fakeSchools(prompt string, seed uint64) map[string]any {
+	amount := 5
+	if m := fakeSchoolAmountRegex.FindStringSubmatch(prompt); m != nil {
+		if n, err := strconv.Atoi(m[1]); err == nil && n > 0 {
+			amount = n
+		}
+	}
+	if amount > len(fakeSchoolNames) {
+		amount = len(fakeSchoolNames)
+	}
+
+	schools := make([]map[string]any, 0, amount)
+	offset := int(seed % uint64(len(fakeSchoolNames)))
+	for i := 0; i < amount; i++ {
+		chance := 90 - i*80/amount
+		category := "Match"
+		switch {
+		case chance >= 80:
+			category = "Safety"
+		case chance < 30:
+			category = "Reach"
+		}
+		schools = append(schools, map[string]any{
+			"name":                   fakeSchoolNames[(offset+i)%len(fakeSchoolNames)],
+			"chance_percent":         chance,
+			"distance_from_location": fmt.Sprintf("%d miles", 100+int((seed>>uint(i))%1900)),
+			"category":               category,
+			"reasoning":              "Deterministic sample generated by the fake LLM provider.",
+		})
+	}
+	return map[string]any{"schools": schools}
+}
+
+func fakeDetails(prompt string) map[string]any {
+	school := "Sample University"
+	if m := fakeCollegeRegex.FindStringSubmatch(prompt); m != nil {
+		school = strings.TrimSpace(m[1])
+	}
+	return map[string]any{
+		"title":      school,
+		"summary":    "Deterministic sample generated by the fake LLM provider.",
+		"lookingFor": []string{"Strong academic record", "Clear interest in the intended major"},
+		"fit": map[string]any{
+			"bullets": []string{"Profile details were not evaluated by a real model."},
+		},
+		"sections": []map[string]string{
+			{"title": "Academics & Curriculum", "text": "Check the school's official site"},
+			{"title": "Admissions Context", "text": "Check the school's official site"},
+			{"title": "Financial Aid Notes", "text": "Check the school's official site"},
+		},
+	}
+}
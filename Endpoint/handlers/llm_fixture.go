@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =====================================================
+//              Fixture (record/replay) provider
+// =====================================================
+//
+// LLM_PROVIDER=fixture serves completions from recorded JSON files so the
+// server can run locally without an OpenAI key:
+//
+//	LLM_FIXTURE_DIR      directory of recordings (default data/llm_fixtures)
+//	LLM_FIXTURE_MODE     replay (default) or record
+//	LLM_FIXTURE_SOURCE   provider used to capture in record mode (default openai)
+//
+// Files live at <dir>/<kind>/<key>.json where key is the same checksumPayload
+// (advisor) or slugify (details) value used by the response caches.
+
+const defaultFixtureDir = "data/llm_fixtures"
+
+var errFixtureMissing = errors.New("no recorded fixture")
+
+type llmFixture struct {
+	Kind       string   `json:"kind"`
+	Key        string   `json:"key"`
+	Model      string   `json:"model"`
+	RecordedAt int64    `json:"recorded_at"`
+	Response   string   `json:"response"`
+	Usage      LLMUsage `json:"usage"`
+}
+
+type fixtureProvider struct {
+	dir    string
+	record bool
+	source string
+
+	mu     sync.Mutex
+	inner  LLMProvider // built lazily in record mode
+	writes sync.Mutex
+}
+
+func newFixtureProvider(c FixtureConfig) (*fixtureProvider, error) {
+	dir := strings.TrimSpace(c.Dir)
+	if dir == "" {
+		dir = defaultFixtureDir
+	}
+
+	p := &fixtureProvider{dir: dir}
+	switch mode := strings.ToLower(strings.TrimSpace(c.Mode)); mode {
+	case "", "replay":
+	case "record":
+		p.record = true
+		p.source = strings.ToLower(strings.TrimSpace(c.Source))
+		if p.source == "" {
+			p.source = "openai"
+		}
+		if p.source == "fixture" {
+			return nil, errors.New("LLM_FIXTURE_SOURCE cannot be fixture")
+		}
+	default:
+		return nil, fmt.Errorf("unknown LLM_FIXTURE_MODE %q (want replay or record)", mode)
+	}
+	return p, nil
+}
+
+func (p *fixtureProvider) Name() string {
+	if p.record {
+		return "fixture(record:" + p.source + ")"
+	}
+	return "fixture"
+}
+
+func (p *fixtureProvider) path(kind, key string) string {
+	return filepath.Join(p.dir, kind, key+".json")
+}
+
+func (p *fixtureProvider) Complete(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	if strings.TrimSpace(req.Key) == "" {
+		return LLMResponse{}, errors.New("fixture provider requires a request key")
+	}
+	if p.record {
+		return p.capture(ctx, req)
+	}
+	return p.replay(ctx, req)
+}
+
+func (p *fixtureProvider) replay(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return LLMResponse{}, err
+	}
+
+	path := p.path(req.Kind, req.Key)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return LLMResponse{}, fmt.Errorf("%w for %s/%s", errFixtureMissing, req.Kind, req.Key)
+		}
+		return LLMResponse{}, err
+	}
+
+	var fx llmFixture
+	if err := json.Unmarshal(b, &fx); err != nil {
+		return LLMResponse{}, fmt.Errorf("bad fixture %s: %w", path, err)
+	}
+
+	// Report the replay as "fixture", not the recorded model, so replayed
+	// usage is never priced or mistaken for a real completion.
+	if req.OnDelta != nil {
+		streamText(fx.Response, req.OnDelta)
+	}
+	return LLMResponse{Text: fx.Response, Model: "fixture", Usage: fx.Usage}, nil
+}
+
+func (p *fixtureProvider) capture(ctx context.Context, req LLMRequest) (LLMResponse, error) {
+	inner, err := p.sourceProvider()
+	if err != nil {
+		return LLMResponse{}, err
+	}
+
+	resp, err := inner.Complete(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+
+	fx := llmFixture{
+		Kind:       req.Kind,
+		Key:        req.Key,
+		Model:      resp.Model,
+		RecordedAt: time.Now().Unix(),
+		Response:   resp.Text,
+		Usage:      resp.Usage,
+	}
+	if err := p.save(fx); err != nil {
+		logWarn("fixtureProvider", "Failed to record fixture",
+			slog.String("kind", req.Kind), slog.String("key", req.Key), slog.Any("error", err))
+	} else {
+		logDebug("fixtureProvider", "Recorded fixture", slog.String("kind", req.Kind), slog.String("key", req.Key))
+	}
+	return resp, nil
+}
+
+// sourceProvider returns the provider recordings are captured from.
+func (p *fixtureProvider) sourceProvider() (LLMProvider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inner != nil {
+		return p.inner, nil
+	}
+	inner, err := newLLMProvider(p.source)
+	if err != nil {
+		return nil, err
+	}
+	p.inner = inner
+	return inner, nil
+}
+
+func (p *fixtureProvider) save(fx llmFixture) error {
+	p.writes.Lock()
+	defer p.writes.Unlock()
+
+	path := p.path(fx.Kind, fx.Key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(fx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o644)
+}
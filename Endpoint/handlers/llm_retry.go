@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// =====================================================
+//              JSON repair-and-retry policy
+// =====================================================
+
+const defaultLLMMaxAttempts = 3
+
+// errLLMInvalidOutput is returned when every attempt produced unusable output.
+var errLLMInvalidOutput = errors.New("model did not return valid JSON")
+
+// llmAttempts is LLM_MAX_ATTEMPTS (limits.llm_max_attempts), set by
+// configureLLM.
+var llmAttempts = defaultLLMMaxAttempts
+
+func llmMaxAttempts() int { return llmAttempts }
+
+// llmJSONResult is the outcome of completeJSON.
+type llmJSONResult struct {
+	JSON     []byte   // parsed output as returned by the parse func
+	Model    string   // model reported by the last attempt
+	Usage    LLMUsage // summed across attempts
+	Attempts int
+}
+
+func (u *LLMUsage) add(o LLMUsage) {
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.ReasoningTokens += o.ReasoningTokens
+	u.TotalTokens += o.TotalTokens
+}
+
+// completeJSON asks the provider for JSON and retries until parse accepts
+// the reply, up to llmMaxAttempts and within ctx:
+//
+//  1. the raw reply is parsed as-is;
+//  2. failing that, a JSON object is extracted from fenced/prose-wrapped text;
+//  3. failing that, the model is re-asked with the parse error appended.
+//
+// Provider (API) errors are returned immediately; they are not output problems.
+// Every attempt is logged, traced and recorded in latency and the LLM metrics.
+func completeJSON(ctx context.Context, provider LLMProvider, req LLMRequest, parse func(string) ([]byte, error), latency *latencyStats, id string) (res llmJSONResult, err error) {
+	maxAttempts := llmMaxAttempts()
+	ctx, call := startSpan(ctx, "llm.complete", spanInternal,
+		attr("job.id", id),
+		attr("llm.kind", req.Kind),
+		attr("gen_ai.system", provider.Name()),
+		attr("gen_ai.request.model", req.Model),
+		attr("llm.max_attempts", maxAttempts),
+	)
+	defer func() {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			metricLLMErrors.inc(req.Kind, errorClass(err))
+			call.set("error.type", errorClass(err))
+			call.fail(err)
+		}
+		call.set("llm.attempts", res.Attempts)
+		call.set("gen_ai.response.model", res.Model)
+		setUsageAttrs(call, res.Usage)
+		call.finish()
+	}()
+
+	baseUser := req.User
+
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			if lastErr != nil {
+				return res, fmt.Errorf("%w: %w (stopped: %v)", errLLMInvalidOutput, lastErr, err)
+			}
+			return res, err
+		}
+		if err := llmBreaker.allow(true); err != nil {
+			return res, err
+		}
+		res.Attempts = attempt
+
+		logDebug("completeJSON", "Starting attempt",
+			slog.String("job_id", id), slog.Int("attempt", attempt), slog.Int("max_attempts", maxAttempts), slog.String("provider", provider.Name()), slog.String("model", req.Model))
+		_, span := startSpan(ctx, "llm.attempt", spanClient,
+			attr("llm.attempt", attempt),
+			attr("gen_ai.system", provider.Name()),
+			attr("gen_ai.request.model", req.Model),
+		)
+		if lastErr != nil {
+			span.set("llm.retry_reason", lastErr.Error())
+		}
+		start := time.Now()
+		resp, err := provider.Complete(ctx, req)
+		elapsed := time.Since(start)
+		latency.record(elapsed)
+		llmBreaker.record(err)
+		model := resp.Model
+		if model == "" {
+			model = req.Model
+		}
+		metricLLMDuration.observe(elapsed.Seconds(), req.Kind, model)
+		logDebug("completeJSON", "Attempt completed",
+			slog.String("job_id", id), slog.Int("attempt", attempt), slog.Duration("elapsed", elapsed))
+		span.set("gen_ai.response.model", model)
+		setUsageAttrs(span, resp.Usage)
+
+		if err != nil {
+			span.fail(err)
+			span.finish()
+			return res, err
+		}
+		res.Model = resp.Model
+		res.Usage.add(resp.Usage)
+
+		out, perr := parse(resp.Text)
+		if perr == nil {
+			span.finish()
+			return res.with(out), nil
+		}
+
+		if candidate, ok := extractJSONObject(resp.Text); ok && candidate != resp.Text {
+			out, xerr := parse(candidate)
+			if xerr == nil {
+				logWarn("completeJSON", "Recovered JSON from wrapped reply", slog.String("job_id", id), slog.Int("attempt", attempt))
+				span.set("llm.json_extracted", true)
+				span.finish()
+				return res.with(out), nil
+			}
+			perr = xerr
+		}
+
+		lastErr = perr
+		span.fail(perr)
+		span.finish()
+		logWarn("completeJSON", "Attempt unusable",
+			slog.String("job_id", id), slog.Int("attempt", attempt), slog.Int("max_attempts", maxAttempts), slog.Any("error", perr), logFail)
+		req.User = baseUser + fmt.Sprintf(
+			"\n\nYour previous reply could not be used: %s\nReturn ONLY the corrected JSON object, with no prose or code fences.",
+			perr,
+		)
+	}
+
+	return res, fmt.Errorf("%w after %d attempt(s): %w", errLLMInvalidOutput, maxAttempts, lastErr)
+}
+
+// setUsageAttrs puts token counts on an LLM span.
+func setUsageAttrs(s *span, u LLMUsage) {
+	s.set("gen_ai.usage.input_tokens", u.PromptTokens)
+	s.set("gen_ai.usage.output_tokens", u.CompletionTokens)
+	s.set("gen_ai.usage.reasoning_tokens", u.ReasoningTokens)
+}
+
+func (r llmJSONResult) with(out []byte) llmJSONResult {
+	r.JSON = out
+	return r
+}
+
+// extractJSONObject returns the first balanced {...} object in text, skipping
+// code fences and surrounding prose. Braces inside strings are ignored.
+func extractJSONObject(text string) (string, bool) {
+	start := strings.IndexByte(text, '{')
+	for start >= 0 {
+		if end, ok := matchBrace(text, start); ok {
+			return text[start : end+1], true
+		}
+
+		// Unbalanced from this brace; try the next one.
+		next := strings.IndexByte(text[start+1:], '{')
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	return "", false
+}
+
+// matchBrace returns the index of the '}' closing the '{' at text[start].
+// Braces inside strings are ignored; ok is false if the object is unclosed.
+func matchBrace(text string, start int) (end int, ok bool) {
+	depth := 0
+	inString, escaped := false, false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
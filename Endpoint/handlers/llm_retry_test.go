@@ -0,0 +1,30 @@
+package handlers
+
+import "testing"
+
+func TestExtractJSONObject(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		want   string
+		wantOK bool
+	}{
+		{"bare object", `{"a":1}`, `{"a":1}`, true},
+		{"code fence", "```json\n{\"a\":1}\n```", `{"a":1}`, true},
+		{"prose around", `Here you go: {"a":{"b":2}} Hope that helps!`, `{"a":{"b":2}}`, true},
+		{"braces in strings", `{"a":"}{","b":"\"}"}`, `{"a":"}{","b":"\"}"}`, true},
+		{"first of two", `{"a":1} {"b":2}`, `{"a":1}`, true},
+		{"unclosed then closed", `{"a": {"b":2}`, `{"b":2}`, true},
+		{"no object", `no json here`, ``, false},
+		{"unclosed", `{"a":1`, ``, false},
+		{"empty", ``, ``, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := extractJSONObject(tt.in)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("extractJSONObject(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// =====================================================
+//                   Structured logging
+// =====================================================
+//
+// logDebug/logInfo/logWarn/logError log through log/slog with a component
+// name, a constant message and explicit attributes (job_id, school, error,
+// outcome, ...). Logs that carry a job_id also get the request_id of the
+// request that created the job.
+//
+//	LOG_LEVEL    debug | info | warn | error (default info; logging.level)
+//	LOG_FORMAT   text | json (default text; logging.format)
+//
+// Student profile fields never reach the output: request structs and raw
+// job inputs are replaced, attributes named after a profile field are
+// redacted, and so are profile values quoted in messages or error text.
+
+var logger atomic.Pointer[slog.Logger]
+
+// appLogger returns the configured logger, or an info-level text logger until
+// configureLogging has run.
+func appLogger() *slog.Logger {
+	if l := logger.Load(); l != nil {
+		return l
+	}
+	l := newLogger(LoggingConfig{Level: "info", Format: "text"})
+	if logger.CompareAndSwap(nil, l) {
+		return l
+	}
+	return logger.Load()
+}
+
+// configureLogging replaces the logger and makes it the default for the
+// standard log package too. The settings are already validated.
+func configureLogging(c LoggingConfig) {
+	l := newLogger(c)
+	logger.Store(l)
+	slog.SetDefault(l)
+}
+
+func newLogger(c LoggingConfig) *slog.Logger {
+	level, _ := parseLogLevel(c.Level)
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	if strings.EqualFold(c.Format, "json") {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// parseLogLevel reads debug, info, warn or error.
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("%q is not debug, info, warn or error", s)
+}
+
+// logOK and logFail mark the outcome of the step being logged.
+var (
+	logOK   = slog.String("outcome", "ok")
+	logFail = slog.String("outcome", "fail")
+)
+
+func logDebug(component, msg string, attrs ...slog.Attr) {
+	logAt(slog.LevelDebug, component, msg, attrs)
+}
+func logInfo(component, msg string, attrs ...slog.Attr) { logAt(slog.LevelInfo, component, msg, attrs) }
+func logWarn(component, msg string, attrs ...slog.Attr) { logAt(slog.LevelWarn, component, msg, attrs) }
+func logError(component, msg string, attrs ...slog.Attr) {
+	logAt(slog.LevelError, component, msg, attrs)
+}
+
+// logAt tags the record with its component and, for job logs, the request
+// that created the job.
+func logAt(level slog.Level, component, msg string, attrs []slog.Attr) {
+	l := appLogger()
+	if !l.Enabled(context.Background(), level) {
+		return
+	}
+	all := make([]slog.Attr, 0, len(attrs)+2)
+	all = append(all, slog.String("component", component))
+	for _, a := range attrs {
+		all = append(all, a)
+		if a.Key != "job_id" {
+			continue
+		}
+		if rid, ok := jobRequestIDs.Load(a.Value.String()); ok {
+			all = append(all, slog.String("request_id", rid.(string)))
+		}
+	}
+	l.LogAttrs(context.Background(), level, msg, all...)
+}
+
+// ---- Redaction ----
+
+const redacted = "[redacted]"
+
+// profileFields are the JSON names of every student profile field.
+var profileFields = func() map[string]bool {
+	fields := map[string]bool{"profile": true, "prompt": true, "input": true}
+	t := reflect.TypeOf(AdvisorRequest{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[strings.ToLower(name)] = true
+		}
+	}
+	return fields
+}()
+
+// profileText finds "field: value" and "field=value" pairs naming a profile
+// field inside free text, such as an error that quotes the request.
+var profileText = func() *regexp.Regexp {
+	names := make([]string, 0, len(profileFields))
+	for name := range profileFields {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	sort.Strings(names)
+	return regexp.MustCompile(`(?i)("?\b(?:` + strings.Join(names, "|") + `)\b"?\s*[:=]\s*)("(?:[^"\\]|\\.)*"|[^,;}\]\s]+)`)
+}()
+
+func redactText(s string) string {
+	return profileText.ReplaceAllString(s, `${1}"`+redacted+`"`)
+}
+
+// redactAttr is the handler's ReplaceAttr hook, so it sees the message too.
+// It hides attributes named after a profile field, request structs and raw
+// job inputs, and profile values quoted in the message or an error.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if profileFields[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if len(groups) == 0 && a.Key == slog.MessageKey {
+			return slog.String(a.Key, redactText(a.Value.String()))
+		}
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case AdvisorRequest, *AdvisorRequest, SchoolDetailsRequest, *SchoolDetailsRequest, json.RawMessage:
+			return slog.String(a.Key, redacted)
+		case error:
+			return slog.String(a.Key, redactText(v.Error()))
+		}
+	}
+	return a
+}
+
+// ---- Request IDs ----
+
+type requestIDKey struct{}
+
+// jobRequestIDs maps job ID to the request that created it, so worker logs
+// can be joined with the request's access log.
+var jobRequestIDs sync.Map
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// WithRequestID gives every request an ID (the caller's X-Request-ID when it
+// is sane, otherwise a new one), echoes it in the response and writes one
+// access log line per request.
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(rid) {
+			rid, _ = genID()
+		}
+		w.Header().Set("X-Request-ID", rid)
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, rid)))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		appLogger().LogAttrs(r.Context(), level, "request",
+			slog.String("request_id", rid),
+			slog.String("method", r.Method),
+			slog.String("scheme", requestScheme(r)),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+			slog.String("client_ip", clientIP(r)),
+		)
+	})
+}
+
+// requestID returns the ID WithRequestID assigned to r, if any.
+func requestID(r *http.Request) string {
+	rid, _ := r.Context().Value(requestIDKey{}).(string)
+	return rid
+}
+
+// tagJobRequest links a job's logs to the request that created it.
+func tagJobRequest(jobID, rid string) {
+	if rid != "" {
+		jobRequestIDs.Store(jobID, rid)
+	}
+}
+
+// untagOrphanRequest forgets the link for an ID whose request was rejected
+// before a job was stored; stored jobs are forgotten by scheduleJobCleanup.
+func untagOrphanRequest(jobID string) {
+	if _, ok := jobs.Get(jobID); !ok {
+		jobRequestIDs.Delete(jobID)
+	}
+}
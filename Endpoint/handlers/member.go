@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// =====================================================
+//                    Member identity
+// =====================================================
+
+type Plan string
+
+const (
+	PlanFree Plan = "free"
+	PlanPro  Plan = "pro"
+)
+
+// Member is the caller behind a request. ID is empty for anonymous callers.
+type Member struct {
+	ID   string
+	Plan Plan
+}
+
+// requestMember returns the caller WithAuth attached to the request, or an
+// anonymous Free caller on routes without authentication.
+func requestMember(r *http.Request) Member {
+	if m, ok := r.Context().Value(memberContextKey{}).(Member); ok {
+		return m
+	}
+	return Member{Plan: PlanFree}
+}
+
+// ---- Client IP ----
+//
+//	TRUSTED_PROXIES   comma-separated IPs/CIDRs whose X-Forwarded-For and
+//	                  X-Forwarded-Proto are honored (listen.trusted_proxies)
+
+// trustedProxies is set by configureTrustedProxies; none are trusted by default.
+var trustedProxies []netip.Prefix
+
+// configureTrustedProxies sets the proxies whose forwarding headers are
+// believed. Invalid entries were already rejected by Config.Validate.
+func configureTrustedProxies(list []string) {
+	var out []netip.Prefix
+	for _, s := range list {
+		if p, err := parseTrustedProxy(strings.TrimSpace(s)); err == nil {
+			out = append(out, p)
+		}
+	}
+	trustedProxies = out
+}
+
+// parseTrustedProxy reads one TRUSTED_PROXIES entry: an IP or a CIDR.
+func parseTrustedProxy(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return p.Masked(), nil
+}
+
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the caller's IP. X-Forwarded-For is only believed when the
+// connection comes from a trusted proxy, and then read right to left up to
+// the first hop that is not itself a trusted proxy.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+// requestScheme returns "https" or "http" as the client saw it: from the
+// connection itself, or from X-Forwarded-Proto when a trusted proxy
+// terminated TLS.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if isTrustedProxy(host) {
+		// The proxy nearest to us appends last.
+		protos := strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")
+		if strings.EqualFold(strings.TrimSpace(protos[len(protos)-1]), "https") {
+			return "https"
+		}
+	}
+	return "http"
+}
+
+// usageKey identifies who plan usage is charged to: the member, or the
+// client IP for anonymous callers.
+func usageKey(r *http.Request, m Member) string {
+	if m.ID != "" {
+		return "member:" + m.ID
+	}
+	return "ip:" + clientIP(r)
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =====================================================
+//                  Prometheus metrics
+// =====================================================
+//
+// GET /metrics serves the Prometheus text format. Counters and histograms are
+// kept in memory; queue gauges are read at scrape time.
+//
+//	METRICS_TOKEN   if set, scrapes must send "Authorization: Bearer <token>"
+
+// ---- Metric types ----
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// metricVec holds one series per label combination.
+type metricVec struct {
+	name   string
+	help   string
+	kind   string // counter | histogram
+	labels []string
+	bounds []float64 // histogram upper bounds
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	values  []string
+	value   float64  // counter value, or histogram sum
+	count   uint64   // histogram observations
+	buckets []uint64 // cumulative per bound
+}
+
+var (
+	metricsMu sync.Mutex
+	registry  []metric
+)
+
+func register(m metric) {
+	metricsMu.Lock()
+	registry = append(registry, m)
+	metricsMu.Unlock()
+}
+
+func newCounterVec(name, help string, labels ...string) *metricVec {
+	v := &metricVec{name: name, help: help, kind: "counter", labels: labels, series: make(map[string]*metricSeries)}
+	register(v)
+	return v
+}
+
+func newHistogramVec(name, help string, bounds []float64, labels ...string) *metricVec {
+	v := &metricVec{name: name, help: help, kind: "histogram", labels: labels, bounds: bounds, series: make(map[string]*metricSeries)}
+	register(v)
+	return v
+}
+
+func (v *metricVec) get(values []string) *metricSeries {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s: want %d label value(s), got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s := v.series[key]
+	if s == nil {
+		s = &metricSeries{values: values}
+		if v.kind == "histogram" {
+			s.buckets = make([]uint64, len(v.bounds))
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+// add increments a counter.
+func (v *metricVec) add(delta float64, values ...string) {
+	v.mu.Lock()
+	v.get(values).value += delta
+	v.mu.Unlock()
+}
+
+func (v *metricVec) inc(values ...string) { v.add(1, values...) }
+
+// observe records one histogram sample.
+func (v *metricVec) observe(x float64, values ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s := v.get(values)
+	s.value += x
+	s.count++
+	for i, b := range v.bounds {
+		if x <= b {
+			s.buckets[i]++
+		}
+	}
+}
+
+func (v *metricVec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := v.series[k]
+		if v.kind == "counter" {
+			fmt.Fprintf(w, "%s%s %s\n", v.name, labelString(v.labels, s.values, "", ""), formatFloat(s.value))
+			continue
+		}
+		for i, b := range v.bounds {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, labelString(v.labels, s.values, "le", formatFloat(b)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, labelString(v.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, labelString(v.labels, s.values, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, labelString(v.labels, s.values, "", ""), s.count)
+	}
+}
+
+// gaugeFunc is a gauge whose series are computed at scrape time.
+type gaugeFunc struct {
+	name, help string
+	labels     []string
+	collect    func(emit func(value float64, labelValues ...string))
+}
+
+func newGaugeFunc(name, help string, collect func(emit func(float64, ...string)), labels ...string) *gaugeFunc {
+	g := &gaugeFunc{name: name, help: help, labels: labels, collect: collect}
+	register(g)
+	return g
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	g.collect(func(value float64, values ...string) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labelString(g.labels, values, "", ""), formatFloat(value))
+	})
+}
+
+func labelString(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+1)
+	for i, n := range names {
+		parts = append(parts, n+"="+strconv.Quote(values[i]))
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+"="+strconv.Quote(extraValue))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// ---- Metrics ----
+
+var (
+	httpBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	llmBuckets  = []float64{1, 5, 10, 20, 30, 60, 90, 120, 180, 300, 600}
+
+	metricHTTPRequests = newCounterVec("aidvisor_http_requests_total",
+		"HTTP requests by route, method and status.", "route", "method", "status")
+	metricHTTPDuration = newHistogramVec("aidvisor_http_request_duration_seconds",
+		"HTTP request duration by route and status class (2xx, 4xx, 5xx, ...).", httpBuckets, "route", "status_class")
+
+	metricLLMDuration = newHistogramVec("aidvisor_llm_call_duration_seconds",
+		"Duration of each LLM call attempt by endpoint and model.", llmBuckets, "endpoint", "model")
+	metricLLMErrors = newCounterVec("aidvisor_llm_errors_total",
+		"LLM requests that ended in an error after all retry attempts, by endpoint and error class; includes requests refused by the circuit breaker or budget, excludes cancellations.", "endpoint", "class")
+	metricLLMTokens = newCounterVec("aidvisor_llm_tokens_total",
+		"LLM tokens by endpoint, model and type (prompt, completion, reasoning).", "endpoint", "model", "type")
+	metricLLMCost = newCounterVec("aidvisor_llm_cost_usd_total",
+		"Estimated LLM spend in USD by endpoint and model.", "endpoint", "model")
+
+	metricCache = newCounterVec("aidvisor_cache_requests_total",
+		"Cache lookups by cache (responses, details) and result (hit, miss).", "cache", "result")
+
+	_ = newGaugeFunc("aidvisor_job_queue_depth", "Jobs waiting for a worker.",
+		func(emit func(float64, ...string)) {
+			for _, k := range []JobKind{JobKindAdvisor, JobKindDetails} {
+				pending, _ := queueFor(k).stats()
+				emit(float64(pending), string(k))
+			}
+		}, "kind")
+	_ = newGaugeFunc("aidvisor_jobs_in_flight", "Jobs currently running on a worker.",
+		func(emit func(float64, ...string)) {
+			for _, k := range []JobKind{JobKindAdvisor, JobKindDetails} {
+				_, running := queueFor(k).stats()
+				emit(float64(running), string(k))
+			}
+		}, "kind")
+	_ = newGaugeFunc("aidvisor_llm_circuit_open", "1 while the LLM circuit breaker is open.",
+		func(emit func(float64, ...string)) {
+			if llmBreaker.retryAfter() > 0 {
+				emit(1)
+			} else {
+				emit(0)
+			}
+		})
+)
+
+// recordCacheLookup counts a hit or miss on cache ("responses" or "details")
+// and traces the lookup, which began at start.
+func recordCacheLookup(ctx context.Context, cache string, start time.Time, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	metricCache.inc(cache, result)
+
+	_, s := startSpanAt(ctx, "cache.lookup", spanInternal, start, attr("cache.name", cache), attr("cache.hit", hit))
+	s.finish()
+}
+
+// ---- HTTP ----
+
+// statusRecorder captures the status code while keeping streaming working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// WithMetrics counts requests and their duration under route.
+func WithMetrics(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		metricHTTPRequests.inc(route, r.Method, strconv.Itoa(rec.status))
+		metricHTTPDuration.observe(time.Since(start).Seconds(), route, statusClass(rec.status))
+	})
+}
+
+// statusClass buckets a status code as "2xx", "4xx", ... to keep the
+// histogram's label set small.
+func statusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// Metrics serves all registered metrics.
+//
+//	GET /metrics
+func Metrics(w http.ResponseWriter, r *http.Request) {
+	if want := strings.TrimSpace(secretsConfig.MetricsToken); want != "" {
+		token, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	metricsMu.Lock()
+	all := append([]metric(nil), registry...)
+	metricsMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range all {
+		m.write(w)
+	}
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// =====================================================
+//                   Free / Pro plan limits
+// =====================================================
+//
+// The frontend hides Pro features, but the server is what actually enforces
+// them. Limits per plan (-1 = unlimited), set from the limits section of the
+// config or the environment:
+//
+//	FREE_MAX_SCHOOLS / PRO_MAX_SCHOOLS   school_amount cap (default 3 / 10)
+//	FREE_MAX_RUNS    / PRO_MAX_RUNS      unique advisor payloads per member (default 2 / -1)
+//	FREE_MAX_DETAILS / PRO_MAX_DETAILS   unique schools with details per member (default 0 / -1)
+//
+// These defaults are the source of truth for the plans: Webflow Code's
+// membership.js mirrors them (3 named schools and no details on Free, up to 10
+// on Pro) only to describe what the server returns. Change both together.
+
+const unlimited = -1
+
+type planLimits struct {
+	MaxSchools int
+	MaxRuns    int
+	MaxDetails int
+}
+
+var defaultPlanLimits = map[Plan]planLimits{
+	PlanFree: {MaxSchools: 3, MaxRuns: 2, MaxDetails: 0},
+	PlanPro:  {MaxSchools: 10, MaxRuns: unlimited, MaxDetails: unlimited},
+}
+
+// planLimitsByPlan holds the limits in force, set by configurePlans.
+var planLimitsByPlan = maps.Clone(defaultPlanLimits)
+
+// configurePlans sets the plan limits and how often allowances reset.
+func configurePlans(c LimitsConfig) {
+	planLimitsByPlan = map[Plan]planLimits{
+		PlanFree: {MaxSchools: c.FreeMaxSchools, MaxRuns: c.FreeMaxRuns, MaxDetails: c.FreeMaxDetails},
+		PlanPro:  {MaxSchools: c.ProMaxSchools, MaxRuns: c.ProMaxRuns, MaxDetails: c.ProMaxDetails},
+	}
+	planUsage.period = time.Duration(c.PlanUsagePeriod)
+}
+
+func limitsFor(plan Plan) planLimits {
+	if l, ok := planLimitsByPlan[plan]; ok {
+		return l
+	}
+	return planLimitsByPlan[PlanFree]
+}
+
+// planLimitError is the structured 403 body the frontend shows as an upgrade
+// prompt.
+type planLimitError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`  // always "plan_limit"
+	Plan  Plan   `json:"plan"`  // caller's plan
+	Limit string `json:"limit"` // runs | details
+	Max   int    `json:"max"`
+	Used  int    `json:"used"`
+}
+
+func writePlanLimit(w http.ResponseWriter, m Member, limit string, maxN, used int, msg string) {
+	writeJSON(w, http.StatusForbidden, planLimitError{
+		Error: msg,
+		Code:  "plan_limit",
+		Plan:  m.Plan,
+		Limit: limit,
+		Max:   maxN,
+		Used:  used,
+	})
+}
+
+// ---- Per-member usage ----
+//
+// Usage is kept per usageKey and saved to data/plan_usage.json so a restart
+// does not hand out fresh allowances. Allowances start over every
+// PLAN_USAGE_PERIOD (e.g. 720h) counted from a member's first use; the default
+// 0 never resets them. A job that fails gives back the run or lookup it used.
+
+const planUsagePath = "data/plan_usage.json"
+
+type memberUsage struct {
+	Since   time.Time `json:"since,omitzero"`    // start of the current period
+	Runs    []string  `json:"runs,omitempty"`    // advisor payload checksums
+	Details []string  `json:"details,omitempty"` // school slugs
+}
+
+type planUsageStore struct {
+	mu     sync.Mutex
+	path   string
+	period time.Duration // 0 = allowances never reset
+	m      map[string]*memberUsage
+}
+
+var planUsage = newPlanUsageStore(planUsagePath)
+
+func newPlanUsageStore(path string) *planUsageStore {
+	s := &planUsageStore{path: path, m: make(map[string]*memberUsage)}
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &s.m); err != nil {
+			logWarn("planUsage", "Ignoring unreadable file", slog.String("path", path), slog.Any("error", err))
+			s.m = make(map[string]*memberUsage)
+		}
+	}
+	return s
+}
+
+// save writes the store; callers hold s.mu.
+func (s *planUsageStore) save() {
+	err := os.MkdirAll(filepath.Dir(s.path), 0o755)
+	var data []byte
+	if err == nil {
+		data, err = json.MarshalIndent(s.m, "", "  ")
+	}
+	if err == nil {
+		err = writeFileAtomic(s.path, data, 0o644)
+	}
+	if err != nil {
+		logWarn("planUsage", "Failed to save usage", slog.String("path", s.path), slog.Any("error", err))
+	}
+}
+
+// consume records item in the list picked by field unless it is already
+// there; repeats never count against maxN. It reports whether the item is
+// allowed, whether it was newly added, and how many distinct items were used
+// before this call.
+func (s *planUsageStore) consume(key, item string, maxN int, field func(*memberUsage) *[]string) (ok, added bool, used int) {
+	if maxN == unlimited {
+		return true, false, 0 // nothing to enforce, so nothing to record
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	u := s.m[key]
+	if u == nil {
+		u = &memberUsage{Since: now}
+		s.m[key] = u
+	}
+	if u.Since.IsZero() {
+		u.Since = now // recorded before periods existed
+	}
+	if s.period > 0 && now.Sub(u.Since) >= s.period {
+		*u = memberUsage{Since: now}
+	}
+	list := field(u)
+	used = len(*list)
+	if slices.Contains(*list, item) {
+		return true, false, used
+	}
+	if used >= maxN {
+		return false, false, used
+	}
+	*list = append(*list, item)
+	s.save()
+	return true, true, used
+}
+
+// release undoes a consume that added item but whose request was not
+// accepted after all.
+func (s *planUsageStore) release(key, item string, field func(*memberUsage) *[]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.m[key]
+	if u == nil {
+		return
+	}
+	list := field(u)
+	if i := slices.Index(*list, item); i >= 0 {
+		*list = slices.Delete(*list, i, i+1)
+		s.save()
+	}
+}
+
+// releaseJobUsage gives back the run or detail lookup a failed or cancelled
+// job used up, so an OpenAI error or a cancel does not cost the member an
+// allowance. If another live job of the member's has the same key (a
+// resubmission that superseded this one), the charge passes to it instead.
+func releaseJobUsage(job Job) {
+	if !job.Charged || job.Member == "" {
+		return
+	}
+	for _, other := range jobs.List() {
+		if other.ID != job.ID && other.Kind == job.Kind && other.Member == job.Member && other.Key == job.Key && !other.State.Final() {
+			jobs.Update(other.ID, func(j *Job) { j.Charged = true })
+			logDebug("releaseJobUsage", "Plan allowance passed to a live job for the same request",
+				slog.String("job_id", job.ID), slog.String("to_job_id", other.ID))
+			return
+		}
+	}
+	field := usageRuns
+	if job.Kind == JobKindDetails {
+		field = usageDetails
+	}
+	planUsage.release(job.Member, job.Key, field)
+	logDebug("releaseJobUsage", "Gave back plan allowance",
+		slog.String("job_id", job.ID), slog.String("member", job.Member), slog.String("kind", string(job.Kind)), slog.String("key", job.Key))
+}
+
+func usageRuns(u *memberUsage) *[]string    { return &u.Runs }
+func usageDetails(u *memberUsage) *[]string { return &u.Details }
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlanUsageConsume(t *testing.T) {
+	type call struct {
+		release  bool // release item instead of consuming it
+		item     string
+		max      int
+		wantOK   bool
+		wantAdd  bool
+		wantUsed int
+	}
+	tests := []struct {
+		name  string
+		calls []call
+	}{
+		{"within the limit", []call{
+			{false, "a", 2, true, true, 0},
+			{false, "b", 2, true, true, 1},
+		}},
+		{"over the limit", []call{
+			{false, "a", 2, true, true, 0},
+			{false, "b", 2, true, true, 1},
+			{false, "c", 2, false, false, 2},
+		}},
+		{"repeats are free", []call{
+			{false, "a", 1, true, true, 0},
+			{false, "a", 1, true, false, 1},
+			{false, "b", 1, false, false, 1},
+		}},
+		{"unlimited records nothing", []call{
+			{false, "a", unlimited, true, false, 0},
+			{false, "b", 1, true, true, 0},
+		}},
+		{"zero allows nothing", []call{
+			{false, "a", 0, false, false, 0},
+		}},
+		{"release gives the allowance back", []call{
+			{false, "a", 1, true, true, 0},
+			{true, "a", 0, false, false, 0},
+			{false, "b", 1, true, true, 0},
+		}},
+		{"release of an unknown item", []call{
+			{false, "a", 1, true, true, 0},
+			{true, "zzz", 0, false, false, 0},
+			{false, "b", 1, false, false, 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPlanUsageStore(filepath.Join(t.TempDir(), "plan_usage.json"))
+			for i, c := range tt.calls {
+				if c.release {
+					s.release("mem_1", c.item, usageRuns)
+					continue
+				}
+				ok, added, used := s.consume("mem_1", c.item, c.max, usageRuns)
+				if ok != c.wantOK || added != c.wantAdd || used != c.wantUsed {
+					t.Errorf("call %d: consume(%q, %d) = %v, %v, %d; want %v, %v, %d",
+						i, c.item, c.max, ok, added, used, c.wantOK, c.wantAdd, c.wantUsed)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanUsageSeparateListsAndMembers(t *testing.T) {
+	s := newPlanUsageStore(filepath.Join(t.TempDir(), "plan_usage.json"))
+	if ok, _, _ := s.consume("mem_1", "a", 1, usageRuns); !ok {
+		t.Fatal("first run refused")
+	}
+	if ok, _, _ := s.consume("mem_1", "mit", 1, usageDetails); !ok {
+		t.Error("a run used up the detail allowance")
+	}
+	if ok, _, _ := s.consume("mem_2", "b", 1, usageRuns); !ok {
+		t.Error("another member's run counted")
+	}
+}
+
+func TestPlanUsagePeriod(t *testing.T) {
+	s := newPlanUsageStore(filepath.Join(t.TempDir(), "plan_usage.json"))
+	s.period = time.Hour
+	s.consume("mem_1", "a", 1, usageRuns)
+	if ok, _, _ := s.consume("mem_1", "b", 1, usageRuns); ok {
+		t.Fatal("second run allowed within the period")
+	}
+	s.m["mem_1"].Since = time.Now().Add(-2 * time.Hour)
+	if ok, added, used := s.consume("mem_1", "b", 1, usageRuns); !ok || !added || used != 0 {
+		t.Errorf("after the period: consume = %v, %v, %d; want a fresh allowance", ok, added, used)
+	}
+}
+
+func TestPlanUsagePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan_usage.json")
+	s := newPlanUsageStore(path)
+	s.consume("mem_1", "a", 1, usageRuns)
+
+	reloaded := newPlanUsageStore(path)
+	if ok, _, used := reloaded.consume("mem_1", "b", 1, usageRuns); ok || used != 1 {
+		t.Errorf("after reload: consume = %v, used %d; want refused with 1 used", ok, used)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if s := newPlanUsageStore(path); len(s.m) != 0 {
+		t.Errorf("unreadable file loaded %d member(s)", len(s.m))
+	}
+}
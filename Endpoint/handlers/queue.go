@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// =====================================================
+//                 Job queue / worker pool
+// =====================================================
+//
+// Each job kind has its own FIFO queue drained by a fixed number of workers:
+//
+//	ADVISOR_WORKERS / DETAILS_WORKERS           concurrent LLM jobs (default 4)
+//	ADVISOR_QUEUE_DEPTH / DETAILS_QUEUE_DEPTH   max waiting jobs (default 50 / 100)
+
+var errQueueFull = errors.New("job queue is full")
+
+const (
+	defaultJobWorkers        = 4
+	defaultAdvisorQueueDepth = 50
+	defaultDetailsQueueDepth = 100
+)
+
+type jobQueue struct {
+	kind    JobKind
+	workers int
+	depth   int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []string // job IDs in FIFO order
+	running int
+}
+
+var (
+	jobQueuesOnce sync.Once
+	jobQueues     map[JobKind]*jobQueue
+)
+
+func newJobQueue(kind JobKind, workers, depth int) *jobQueue {
+	q := &jobQueue{kind: kind, workers: workers, depth: depth}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// jobQueueConfig is the jobs section of the config, set by
+// configureJobQueues.
+var jobQueueConfig = JobsConfig{
+	Store:             "file",
+	StoreDir:          defaultJobStoreDir,
+	AdvisorWorkers:    defaultJobWorkers,
+	AdvisorQueueDepth: defaultAdvisorQueueDepth,
+	DetailsWorkers:    defaultJobWorkers,
+	DetailsQueueDepth: defaultDetailsQueueDepth,
+}
+
+// configureJobQueues sets the worker counts and depths. The queues are built
+// on first use, so this must run before any job is queued.
+func configureJobQueues(c JobsConfig) {
+	jobQueueConfig = c
+}
+
+// startJobQueues builds the queues and starts their workers on first use.
+func startJobQueues() {
+	jobQueuesOnce.Do(func() {
+		c := jobQueueConfig
+		jobQueues = map[JobKind]*jobQueue{
+			JobKindAdvisor: newJobQueue(JobKindAdvisor, c.AdvisorWorkers, c.AdvisorQueueDepth),
+			JobKindDetails: newJobQueue(JobKindDetails, c.DetailsWorkers, c.DetailsQueueDepth),
+		}
+		for _, q := range jobQueues {
+			for i := 0; i < q.workers; i++ {
+				go q.work()
+			}
+			logDebug("startJobQueues", "Queue started",
+				slog.String("kind", string(q.kind)), slog.Int("workers", q.workers), slog.Int("depth", q.depth))
+		}
+	})
+}
+
+func queueFor(kind JobKind) *jobQueue {
+	startJobQueues()
+	return jobQueues[kind]
+}
+
+// enqueue adds a job ID and returns its 1-based queue position. force skips
+// the depth limit (used when resuming jobs after a restart).
+func (q *jobQueue) enqueue(id string, force bool) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !force && len(q.pending) >= q.depth {
+		return 0, errQueueFull
+	}
+	q.pending = append(q.pending, id)
+	q.cond.Signal()
+	return len(q.pending), nil
+}
+
+// position returns the 1-based position of a waiting job, or 0 if it is not
+// waiting in this queue.
+func (q *jobQueue) position(id string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, p := range q.pending {
+		if p == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// remove drops a waiting job and reports whether it was queued.
+func (q *jobQueue) remove(id string) bool {
+	q.mu.Lock()
+	idx := -1
+	for i, p := range q.pending {
+		if p == id {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		q.mu.Unlock()
+		return false
+	}
+	q.pending = append(q.pending[:idx], q.pending[idx+1:]...)
+	behind := append([]string(nil), q.pending[idx:]...)
+	q.mu.Unlock()
+
+	for _, w := range behind {
+		notifyJob(w)
+	}
+	return true
+}
+
+// stats returns waiting and running job counts.
+func (q *jobQueue) stats() (pending, running int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending), q.running
+}
+
+func (q *jobQueue) work() {
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 || isDraining() {
+			q.cond.Wait() // while draining, queued jobs wait for the next start
+		}
+		id := q.pending[0]
+		q.pending = q.pending[1:]
+		q.running++
+		waiting := append([]string(nil), q.pending...)
+		q.mu.Unlock()
+
+		// Everyone still waiting moved up one place.
+		for _, w := range waiting {
+			notifyJob(w)
+		}
+
+		if job, ok := jobs.Get(id); ok && job.State == JobQueued {
+			endSpan := traceJobRun(job)
+			if err := runJob(job); err != nil {
+				logError("jobQueue", "Cannot run job",
+					slog.String("job_id", id), slog.String("kind", string(job.Kind)), slog.Any("error", err), logFail)
+				failJob(id, "Unable to process your request at this time. Please try again later.", job.Attempts)
+			}
+			endSpan()
+		}
+
+		q.mu.Lock()
+		q.running--
+		q.mu.Unlock()
+	}
+}
+
+// retryAfterSeconds estimates when a full queue will have room, based on the
+// median job latency and the number of workers.
+func (q *jobQueue) retryAfterSeconds() int {
+	latency := AdvisorLatency
+	if q.kind == JobKindDetails {
+		latency = DetailsLatency
+	}
+	p50 := latency.estimate(latencySegment{}).P50Ms
+	secs := int(math.Ceil(p50 / 1000 / float64(q.workers)))
+	return max(5, min(secs, 600))
+}
+
+// enqueueJob queues a stored job for its kind's workers.
+func enqueueJob(job Job, force bool) (int, error) {
+	q := queueFor(job.Kind)
+	if q == nil {
+		return 0, fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+	return q.enqueue(job.ID, force)
+}
+
+// dequeueJob removes a job that has not started yet from its queue.
+func dequeueJob(job Job) {
+	if q := queueFor(job.Kind); q != nil {
+		q.remove(job.ID)
+	}
+}
+
+// jobQueuePosition returns the 1-based queue position of a waiting job, or 0.
+func jobQueuePosition(job Job) int {
+	if job.State != JobQueued {
+		return 0
+	}
+	if q := queueFor(job.Kind); q != nil {
+		return q.position(job.ID)
+	}
+	return 0
+}
+
+// writeQueueFull answers a POST whose job could not be queued.
+func writeQueueFull(w http.ResponseWriter, kind JobKind) {
+	retry := queueFor(kind).retryAfterSeconds()
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+		"error":       "High traffic detected. Please wait a moment and try again.",
+		"retry_after": retry,
+	})
+}
+
+// runJob runs a stored job on the calling goroutine from its input.
+func runJob(job Job) error {
+	switch job.Kind {
+	case JobKindAdvisor:
+		var req AdvisorRequest
+		if err := json.Unmarshal(job.Input, &req); err != nil {
+			return err
+		}
+		if invalid := validate(req); len(invalid) > 0 {
+			return errors.New("stored advisor input no longer validates")
+		}
+		Aidvisor_ChatGpt(buildPrompt(req), job.ID, job.Key, schoolAmountOf(req))
+	case JobKindDetails:
+		var req SchoolDetailsRequest
+		if err := json.Unmarshal(job.Input, &req); err != nil {
+			return err
+		}
+		school := strings.TrimSpace(req.School)
+		if school == "" {
+			return errors.New("stored details input has no school")
+		}
+		SchoolDetails_ChatGpt(req, school, cachePathForSchool(school), job.ID)
+	default:
+		return fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// fillQueue makes the kind's queue report full until the returned func is
+// called or the test ends.
+func fillQueue(t *testing.T, kind JobKind) (unfill func()) {
+	t.Helper()
+	q := queueFor(kind)
+	q.mu.Lock()
+	depth := q.depth
+	q.depth = len(q.pending)
+	q.mu.Unlock()
+	unfill = func() {
+		q.mu.Lock()
+		q.depth = depth
+		q.mu.Unlock()
+	}
+	t.Cleanup(unfill)
+	return unfill
+}
+
+func TestQueueFullReturns503(t *testing.T) {
+	srv := newTestServer(t)
+	unfill := fillQueue(t, JobKindAdvisor)
+
+	var reply struct {
+		Error      string `json:"error"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	resp := postJSON(t, srv, "/CollegeAdvisor", testProfile, &reply)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("submit to a full queue: status %d, want 503", resp.StatusCode)
+	}
+	header := resp.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(header); err != nil || secs < 5 || secs != reply.RetryAfter {
+		t.Errorf("Retry-After %q, retry_after %d; want the same number of seconds, at least 5", header, reply.RetryAfter)
+	}
+	if reply.Error == "" {
+		t.Error("503 reply has no error message")
+	}
+	if n := len(jobs.List()); n != 0 {
+		t.Errorf("rejected job was kept: %d job(s) in the store", n)
+	}
+
+	// The refused submission did not use up a Free run.
+	unfill()
+	for _, gpa := range []string{"3.2", "3.3"} {
+		if status, reply := submitAdvisor(t, srv, gpa, ""); status != http.StatusOK {
+			t.Fatalf("run with gpa %s: status %d, %v; want 200", gpa, status, reply)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =====================================================
+//                    Rate limiting
+// =====================================================
+//
+// WithRateLimit throttles a route with a token bucket per caller: the member
+// id when WithAuth resolved one, otherwise the client IP. Each class of route
+// has its own budget, written as <requests>/<window> (the bucket holds
+// <requests> and refills over <window>):
+//
+//	RATE_LIMIT_ADVISOR   advisor runs   (default 20/1h; limits.rate_advisor)
+//	RATE_LIMIT_DETAILS   detail lookups (default 60/1h; limits.rate_details)
+//	RATE_LIMIT_POLL      fetch/status/stream/cancel requests (default 120/1m; limits.rate_poll)
+
+type RateClass string
+
+const (
+	RateAdvisor RateClass = "advisor"
+	RateDetails RateClass = "details"
+	RatePoll    RateClass = "poll"
+)
+
+type rateBudget struct {
+	Limit  int
+	Window time.Duration
+}
+
+var defaultRateBudgets = map[RateClass]rateBudget{
+	RateAdvisor: {Limit: 20, Window: time.Hour},
+	RateDetails: {Limit: 60, Window: time.Hour},
+	RatePoll:    {Limit: 120, Window: time.Minute},
+}
+
+// rateLimiter holds the buckets for one class.
+type rateLimiter struct {
+	class  RateClass
+	budget rateBudget
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = make(map[RateClass]*rateLimiter)
+	rateBudgets    = maps.Clone(defaultRateBudgets) // set by configureRateLimits
+)
+
+// configureRateLimits sets each class's budget from the limits section of
+// the config, which Config.Validate has already checked. Limiters are built
+// on first use, so this must run before serving requests.
+func configureRateLimits(c LimitsConfig) {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	for class, v := range map[RateClass]string{RateAdvisor: c.RateAdvisor, RateDetails: c.RateDetails, RatePoll: c.RatePoll} {
+		if b, err := parseRateBudget(v); err == nil {
+			rateBudgets[class] = b
+		}
+	}
+}
+
+func rateLimiterFor(class RateClass) *rateLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	if l, ok := rateLimiters[class]; ok {
+		return l
+	}
+	l := &rateLimiter{
+		class:   class,
+		budget:  rateBudgets[class],
+		buckets: make(map[string]*tokenBucket),
+	}
+	rateLimiters[class] = l
+	go l.sweep()
+	logDebug("rateLimiter", "Rate limit",
+		slog.String("class", string(class)), slog.Int("limit", l.budget.Limit), slog.Duration("window", l.budget.Window))
+	return l
+}
+
+// parseRateBudget parses "<requests>/<window>", e.g. "20/1h" or "120/1m".
+func parseRateBudget(s string) (rateBudget, error) {
+	n, w, ok := strings.Cut(s, "/")
+	if !ok {
+		return rateBudget{}, fmt.Errorf("want <requests>/<window>")
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(n))
+	if err != nil || limit < 1 {
+		return rateBudget{}, fmt.Errorf("bad request count %q", n)
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(w))
+	if err != nil || window <= 0 {
+		return rateBudget{}, fmt.Errorf("bad window %q", w)
+	}
+	return rateBudget{Limit: limit, Window: window}, nil
+}
+
+// take spends one token for key. It returns whether the request may proceed,
+// the whole tokens left, how long until one more token is available, and
+// how long until the bucket is full again.
+func (l *rateLimiter) take(key string, now time.Time) (ok bool, remaining int, retryAfter, reset time.Duration) {
+	limit := float64(l.budget.Limit)
+	perToken := l.budget.Window / time.Duration(l.budget.Limit)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &tokenBucket{tokens: limit, last: now}
+		l.buckets[key] = b
+	}
+	elapsed := now.Sub(b.last)
+	b.tokens = math.Min(limit, b.tokens+elapsed.Seconds()/perToken.Seconds())
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	} else {
+		retryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	reset = time.Duration((limit - b.tokens) * float64(perToken))
+	return ok, int(b.tokens), retryAfter, reset
+}
+
+// sweep drops buckets that have refilled completely, so idle callers do not
+// pin memory.
+func (l *rateLimiter) sweep() {
+	ticker := time.NewTicker(l.budget.Window)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if now.Sub(b.last) >= l.budget.Window {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// WithRateLimit wraps a handler with the budget for class. Wrap it inside
+// WithAuth so members are limited by id rather than IP.
+func WithRateLimit(class RateClass, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := rateLimiterFor(class)
+		key := usageKey(r, requestMember(r))
+		ok, remaining, retryAfter, reset := l.take(key, time.Now())
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(l.budget.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+
+		if !ok {
+			secs := ceilSeconds(retryAfter)
+			logWarn("WithRateLimit", "Over rate limit",
+				slog.String("caller", key), slog.String("class", string(class)), slog.Int("retry_after_s", secs), logFail)
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			writeJSON(w, http.StatusTooManyRequests, map[string]any{
+				"error":       "Too many requests. Please wait a moment and try again.",
+				"code":        "rate_limited",
+				"retry_after": secs,
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
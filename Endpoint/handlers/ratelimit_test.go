@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateBudget(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    rateBudget
+		wantErr bool
+	}{
+		{"20/1h", rateBudget{Limit: 20, Window: time.Hour}, false},
+		{"120/1m", rateBudget{Limit: 120, Window: time.Minute}, false},
+		{" 5 / 30s ", rateBudget{Limit: 5, Window: 30 * time.Second}, false},
+		{"1/1ms", rateBudget{Limit: 1, Window: time.Millisecond}, false},
+		{"20", rateBudget{}, true},
+		{"", rateBudget{}, true},
+		{"0/1h", rateBudget{}, true},
+		{"-1/1h", rateBudget{}, true},
+		{"x/1h", rateBudget{}, true},
+		{"20/", rateBudget{}, true},
+		{"20/1 hour", rateBudget{}, true},
+		{"20/0s", rateBudget{}, true},
+		{"20/-1m", rateBudget{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRateBudget(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRateBudget(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRateBudget(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// 3 requests per minute: a token every 20s.
+	type step struct {
+		key           string
+		at            time.Duration // since start
+		wantOK        bool
+		wantRemaining int
+		wantRetry     time.Duration
+		wantReset     time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"burst then refused", []step{
+			{"a", 0, true, 2, 0, 20 * time.Second},
+			{"a", 0, true, 1, 0, 40 * time.Second},
+			{"a", 0, true, 0, 0, time.Minute},
+			{"a", 0, false, 0, 20 * time.Second, time.Minute},
+		}},
+		{"refills over time", []step{
+			{"a", 0, true, 2, 0, 20 * time.Second},
+			{"a", 0, true, 1, 0, 40 * time.Second},
+			{"a", 0, true, 0, 0, time.Minute},
+			{"a", 10 * time.Second, false, 0, 10 * time.Second, 50 * time.Second},
+			{"a", 20 * time.Second, true, 0, 0, time.Minute},
+			{"a", 2 * time.Minute, true, 2, 0, 20 * time.Second},
+		}},
+		{"keys are independent", []step{
+			{"a", 0, true, 2, 0, 20 * time.Second},
+			{"a", 0, true, 1, 0, 40 * time.Second},
+			{"a", 0, true, 0, 0, time.Minute},
+			{"b", 0, true, 2, 0, 20 * time.Second},
+			{"a", 0, false, 0, 20 * time.Second, time.Minute},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &rateLimiter{
+				class:   RatePoll,
+				budget:  rateBudget{Limit: 3, Window: time.Minute},
+				buckets: make(map[string]*tokenBucket),
+			}
+			for i, s := range tt.steps {
+				ok, remaining, retry, reset := l.take(s.key, start.Add(s.at))
+				if ok != s.wantOK || remaining != s.wantRemaining || retry != s.wantRetry || reset != s.wantReset {
+					t.Errorf("step %d: take(%q, +%s) = %v, %d, %s, %s; want %v, %d, %s, %s", i,
+						s.key, s.at, ok, remaining, retry, reset, s.wantOK, s.wantRemaining, s.wantRetry, s.wantReset)
+				}
+			}
+		})
+	}
+}
+
+func TestCeilSeconds(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want int
+	}{
+		{0, 0},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{time.Minute, 60},
+	}
+	for _, tt := range tests {
+		if got := ceilSeconds(tt.in); got != tt.want {
+			t.Errorf("ceilSeconds(%s) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
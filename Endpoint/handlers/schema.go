@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// =====================================================
+//              Advisor result contract (schools[])
+// =====================================================
+
+// AdvisorSchool is one recommended school in the success shape.
+type AdvisorSchool struct {
+	Name                 string `json:"name"`
+	ChancePercent        int    `json:"chance_percent"`
+	DistanceFromLocation string `json:"distance_from_location"`
+	Category             string `json:"category"`
+	Reasoning            string `json:"reasoning"`
+}
+
+// AdvisorResult is the success shape: {"schools":[...]}.
+type AdvisorResult struct {
+	Schools []AdvisorSchool `json:"schools"`
+}
+
+// AdvisorInvalidFields is the error shape: {"invalid_fields":{...}}.
+type AdvisorInvalidFields struct {
+	InvalidFields map[string]string `json:"invalid_fields"`
+}
+
+const (
+	categoryReach  = "Reach"
+	categoryMatch  = "Match"
+	categorySafety = "Safety"
+)
+
+// LLMSchema is a JSON Schema sent to the provider as the response format.
+type LLMSchema struct {
+	Name        string
+	Description string
+	Schema      map[string]any
+	Strict      bool
+}
+
+// advisorResultSchema describes both result shapes in one object. Strict mode
+// is off because invalid_fields is a free-form map, which strict schemas
+// cannot express; normalizeAdvisorResult enforces the contract server-side.
+var advisorResultSchema = &LLMSchema{
+	Name:        "college_advisor_result",
+	Description: "Either schools[] recommendations or invalid_fields{} for unusable input.",
+	Schema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"schools": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"name":                   map[string]any{"type": "string", "minLength": 1},
+						"chance_percent":         map[string]any{"type": "integer", "minimum": 0, "maximum": 100},
+						"distance_from_location": map[string]any{"type": "string"},
+						"category":               map[string]any{"type": "string", "enum": []string{categoryReach, categoryMatch, categorySafety}},
+						"reasoning":              map[string]any{"type": "string"},
+					},
+					"required":             []string{"name", "chance_percent", "distance_from_location", "category", "reasoning"},
+					"additionalProperties": false,
+				},
+			},
+			"invalid_fields": map[string]any{
+				"type":                 "object",
+				"additionalProperties": map[string]any{"type": "string"},
+			},
+		},
+		"additionalProperties": false,
+	},
+}
+
+var errNoValidSchools = errors.New("model returned no usable schools")
+
+// advisorSchoolRaw accepts the loose shapes models actually produce
+// (e.g. "75%" or 75.4 for chance_percent) so they can be repaired.
+type advisorSchoolRaw struct {
+	Name                 string `json:"name"`
+	ChancePercent        any    `json:"chance_percent"`
+	DistanceFromLocation any    `json:"distance_from_location"`
+	Category             string `json:"category"`
+	Reasoning            string `json:"reasoning"`
+}
+
+// normalizeAdvisorResult parses model output into the schools[] or
+// invalid_fields{} contract, repairing what it can and dropping items it
+// cannot. want caps the number of schools (0 = no cap). The returned notes
+// describe every repair or rejection for logging.
+func normalizeAdvisorResult(text string, want int) ([]byte, []string, error) {
+	var envelope struct {
+		Schools       []json.RawMessage `json:"schools"`
+		InvalidFields map[string]any    `json:"invalid_fields"`
+	}
+	if err := json.Unmarshal([]byte(text), &envelope); err != nil {
+		return nil, nil, err
+	}
+
+	var notes []string
+
+	if len(envelope.Schools) == 0 {
+		if len(envelope.InvalidFields) == 0 {
+			return nil, nil, errNoValidSchools
+		}
+		out := AdvisorInvalidFields{InvalidFields: make(map[string]string, len(envelope.InvalidFields))}
+		for k, v := range envelope.InvalidFields {
+			if s, ok := v.(string); ok {
+				out.InvalidFields[k] = s
+			} else {
+				out.InvalidFields[k] = fmt.Sprint(v)
+				notes = append(notes, fmt.Sprintf("invalid_fields[%q]: coerced non-string message", k))
+			}
+		}
+		b, err := json.Marshal(out)
+		return b, notes, err
+	}
+	if len(envelope.InvalidFields) > 0 {
+		notes = append(notes, "dropped invalid_fields returned alongside schools")
+	}
+
+	schools := make([]AdvisorSchool, 0, len(envelope.Schools))
+	for i, raw := range envelope.Schools {
+		school, itemNotes, ok := normalizeAdvisorSchool(raw)
+		for _, n := range itemNotes {
+			notes = append(notes, fmt.Sprintf("schools[%d]: %s", i, n))
+		}
+		if ok {
+			schools = append(schools, school)
+		}
+	}
+	if len(schools) == 0 {
+		return nil, notes, errNoValidSchools
+	}
+
+	sort.SliceStable(schools, func(i, j int) bool {
+		return schools[i].ChancePercent > schools[j].ChancePercent
+	})
+	if want > 0 && len(schools) > want {
+		notes = append(notes, fmt.Sprintf("truncated %d schools to %d", len(schools), want))
+		schools = schools[:want]
+	}
+
+	b, err := json.Marshal(AdvisorResult{Schools: schools})
+	return b, notes, err
+}
+
+func normalizeAdvisorSchool(raw json.RawMessage) (AdvisorSchool, []string, bool) {
+	var in advisorSchoolRaw
+	if err := json.Unmarshal(raw, &in); err != nil {
+		return AdvisorSchool{}, []string{"rejected: " + err.Error()}, false
+	}
+
+	var notes []string
+	out := AdvisorSchool{
+		Name:      strings.TrimSpace(in.Name),
+		Reasoning: strings.TrimSpace(in.Reasoning),
+	}
+	if out.Name == "" {
+		return out, []string{"rejected: empty name"}, false
+	}
+
+	chance, ok := parseChancePercent(in.ChancePercent)
+	if !ok {
+		return out, []string{fmt.Sprintf("rejected %q: unusable chance_percent %v", out.Name, in.ChancePercent)}, false
+	}
+	if chance < 0 || chance > 100 {
+		notes = append(notes, fmt.Sprintf("clamped chance_percent %d", chance))
+		chance = max(0, min(100, chance))
+	}
+	out.ChancePercent = chance
+
+	switch v := in.DistanceFromLocation.(type) {
+	case string:
+		out.DistanceFromLocation = strings.TrimSpace(v)
+	case float64:
+		out.DistanceFromLocation = fmt.Sprintf("%d miles", int(math.Round(v)))
+		notes = append(notes, "formatted numeric distance_from_location")
+	}
+
+	if cat, ok := normalizeCategory(in.Category); ok {
+		if cat != in.Category {
+			notes = append(notes, fmt.Sprintf("category %q -> %q", in.Category, cat))
+		}
+		out.Category = cat
+	} else {
+		out.Category = categoryForChance(chance)
+		notes = append(notes, fmt.Sprintf("category %q -> %q (from chance)", in.Category, out.Category))
+	}
+
+	return out, notes, true
+}
+
+func parseChancePercent(v any) (int, bool) {
+	switch c := v.(type) {
+	case float64:
+		return int(math.Round(c)), true
+	case string:
+		s := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(c), "%"))
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, false
+		}
+		return int(math.Round(f)), true
+	default:
+		return 0, false
+	}
+}
+
+// normalizeCategory maps the enum and common synonyms onto Reach/Match/Safety.
+func normalizeCategory(s string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "reach", "stretch", "far reach", "high reach":
+		return categoryReach, true
+	case "match", "target":
+		return categoryMatch, true
+	case "safety", "likely", "safe":
+		return categorySafety, true
+	default:
+		return "", false
+	}
+}
+
+func categoryForChance(chance int) string {
+	switch {
+	case chance >= 75:
+		return categorySafety
+	case chance <= 30:
+		return categoryReach
+	default:
+		return categoryMatch
+	}
+}
+
+// ---- Incremental parsing of a streamed reply ----
+
+var schoolsArrayStart = regexp.MustCompile(`"schools"\s*:\s*\[`)
+
+// schoolStream collects the schools already complete in an advisor reply
+// that is still streaming, repaired like the final result. Each feed resumes
+// after the last complete object instead of re-parsing the whole reply, so a
+// long response costs linear time overall. Unusable items are skipped; at
+// most want are kept (0 = no cap).
+type schoolStream struct {
+	want     int
+	searched int  // bytes already searched for the array start
+	next     int  // offset to resume scanning at; 0 until the array is found
+	done     bool // the array ended or want was reached
+	schools  []AdvisorSchool
+}
+
+func newSchoolStream(want int) *schoolStream {
+	return &schoolStream{want: want}
+}
+
+// feed takes the whole reply so far and returns the schools complete in it.
+// A reply shorter than the last one means the stream restarted for a retry,
+// so parsing starts over.
+func (s *schoolStream) feed(text string) []AdvisorSchool {
+	if len(text) < s.searched {
+		*s = schoolStream{want: s.want}
+	}
+	if s.next == 0 {
+		// Back off a little so a match split across deltas is still found.
+		from := max(0, s.searched-64)
+		s.searched = len(text)
+		loc := schoolsArrayStart.FindStringIndex(text[from:])
+		if loc == nil {
+			return nil
+		}
+		s.next = from + loc[1]
+	}
+	s.searched = len(text)
+
+	for !s.done && s.next < len(text) {
+		switch text[s.next] {
+		case ' ', '\t', '\r', '\n', ',':
+			s.next++
+		case '{':
+			end, ok := matchBrace(text, s.next)
+			if !ok {
+				return s.schools // the object is still streaming
+			}
+			if school, _, ok := normalizeAdvisorSchool(json.RawMessage(text[s.next : end+1])); ok {
+				s.schools = append(s.schools, school)
+				s.done = s.want > 0 && len(s.schools) == s.want
+			}
+			s.next = end + 1
+		default:
+			// ']' or anything unexpected ends the array.
+			s.done = true
+		}
+	}
+	return s.schools
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeAdvisorResult(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		want      int // school cap
+		out       string
+		wantNotes int
+		wantErr   error
+	}{
+		{
+			name: "already valid",
+			in:   `{"schools":[{"name":"A","chance_percent":80,"distance_from_location":"5 miles","category":"Safety","reasoning":"r"}]}`,
+			out:  `{"schools":[{"name":"A","chance_percent":80,"distance_from_location":"5 miles","category":"Safety","reasoning":"r"}]}`,
+		},
+		{
+			name:      "repairs loose values and sorts by chance",
+			in:        `{"schools":[{"name":" A ","chance_percent":"40%","distance_from_location":12.4,"category":"target","reasoning":"r"},{"name":"B","chance_percent":120,"distance_from_location":"far","category":"","reasoning":"r"}]}`,
+			out:       `{"schools":[{"name":"B","chance_percent":100,"distance_from_location":"far","category":"Safety","reasoning":"r"},{"name":"A","chance_percent":40,"distance_from_location":"12 miles","category":"Match","reasoning":"r"}]}`,
+			wantNotes: 4,
+		},
+		{
+			name:      "drops unusable schools",
+			in:        `{"schools":[{"name":"","chance_percent":50},{"name":"B","chance_percent":"high"},{"name":"C","chance_percent":20,"category":"Reach"}]}`,
+			out:       `{"schools":[{"name":"C","chance_percent":20,"distance_from_location":"","category":"Reach","reasoning":""}]}`,
+			wantNotes: 2,
+		},
+		{
+			name:      "caps to want",
+			in:        `{"schools":[{"name":"A","chance_percent":10,"category":"Reach"},{"name":"B","chance_percent":90,"category":"Safety"},{"name":"C","chance_percent":50,"category":"Match"}]}`,
+			want:      2,
+			out:       `{"schools":[{"name":"B","chance_percent":90,"distance_from_location":"","category":"Safety","reasoning":""},{"name":"C","chance_percent":50,"distance_from_location":"","category":"Match","reasoning":""}]}`,
+			wantNotes: 1,
+		},
+		{
+			name:      "invalid fields",
+			in:        `{"invalid_fields":{"GPA":"Must be numeric","Year":2020}}`,
+			out:       `{"invalid_fields":{"GPA":"Must be numeric","Year":"2020"}}`,
+			wantNotes: 1,
+		},
+		{
+			name:      "schools win over invalid fields",
+			in:        `{"schools":[{"name":"A","chance_percent":50,"category":"Match"}],"invalid_fields":{"GPA":"x"}}`,
+			out:       `{"schools":[{"name":"A","chance_percent":50,"distance_from_location":"","category":"Match","reasoning":""}]}`,
+			wantNotes: 1,
+		},
+		{
+			name:      "nothing usable",
+			in:        `{"schools":[{"name":"A","chance_percent":null}]}`,
+			wantNotes: 1,
+			wantErr:   errNoValidSchools,
+		},
+		{
+			name:    "empty object",
+			in:      `{}`,
+			wantErr: errNoValidSchools,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, notes, err := normalizeAdvisorResult(tt.in, tt.want)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if string(out) != tt.out {
+				t.Errorf("output =\n  %s\nwant\n  %s", out, tt.out)
+			}
+			if len(notes) != tt.wantNotes {
+				t.Errorf("notes = %q, want %d", notes, tt.wantNotes)
+			}
+		})
+	}
+
+	if _, _, err := normalizeAdvisorResult(`not json`, 0); err == nil {
+		t.Error("accepted text that is not JSON")
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =====================================================
+//                 Reloadable secrets
+// =====================================================
+//
+// The OpenAI key comes from OPENAI_SECRETS_PATH (default data/openai.json),
+// falling back to OPENAI_API_KEY. The file is re-read when it changes
+// (checked every RELOAD_INTERVAL) or on SIGHUP. A file that fails to load
+// keeps the previous key; when the key changes, OpenAI clients are rebuilt
+// on next use.
+
+type secretFile struct {
+	OpenAIKey string `json:"openai_api_key"`
+}
+
+var errNoAPIKey = errors.New("Backend Key Issue.")
+
+type secretStore struct {
+	mu     sync.RWMutex
+	val    secretFile
+	source string    // file path or "OPENAI_API_KEY"
+	stamp  fileStamp // of the file the key came from
+	ok     bool
+}
+
+var secrets = &secretStore{}
+
+// secretsConfig is the secrets section of the config (the key file and the
+// admin, metrics and member tokens), set by configureSecrets.
+var secretsConfig = SecretsConfig{ReloadInterval: Duration(30 * time.Second), OpenAIKeyFile: "data/openai.json"}
+
+// configureSecrets sets where the OpenAI key comes from and the tokens the
+// admin, metrics and member auth check. A key already loaded stays in use
+// until the next reload.
+func configureSecrets(c SecretsConfig) {
+	secretsConfig = c
+}
+
+func secretsPath() string {
+	return secretsConfig.OpenAIKeyFile
+}
+
+// getAPIKey returns the current OpenAI key, loading it on first use. Until a
+// key has been found every call tries again, so a key added later is used.
+func getAPIKey() (string, error) {
+	secrets.mu.RLock()
+	val, ok := secrets.val, secrets.ok
+	secrets.mu.RUnlock()
+	if ok {
+		return val.OpenAIKey, nil
+	}
+	if err := secrets.reload("first use"); err != nil {
+		return "", errNoAPIKey
+	}
+	secrets.mu.RLock()
+	defer secrets.mu.RUnlock()
+	return secrets.val.OpenAIKey, nil
+}
+
+// readSecrets loads the key from the secrets file, or else the environment.
+func readSecrets() (val secretFile, source string, stamp fileStamp, err error) {
+	path := secretsPath()
+	b, ferr := os.ReadFile(path)
+	if ferr == nil {
+		stamp = stampOf(path)
+		if jerr := json.Unmarshal(b, &val); jerr != nil {
+			ferr = fmt.Errorf("%s: %w", path, jerr)
+		} else if strings.TrimSpace(val.OpenAIKey) == "" {
+			ferr = fmt.Errorf("%s: no openai_api_key", path)
+		} else {
+			return val, path, stamp, nil
+		}
+	}
+	if env := strings.TrimSpace(secretsConfig.OpenAIAPIKey); env != "" {
+		return secretFile{OpenAIKey: env}, "OPENAI_API_KEY", stamp, nil
+	}
+	return secretFile{}, "", stamp, ferr
+}
+
+// reload re-reads the key and logs the outcome. On failure the previous key
+// stays in use.
+func (s *secretStore) reload(reason string) error {
+	val, source, stamp, err := readSecrets()
+
+	s.mu.Lock()
+	if err != nil {
+		s.stamp = stamp // don't retry an unchanged broken file every tick
+		hadKey := s.ok
+		s.mu.Unlock()
+		if hadKey {
+			logError("secrets", "Reload failed, keeping previous OpenAI key",
+				slog.String("reason", reason), slog.Any("error", err), logFail)
+		} else {
+			logError("secrets", "No OpenAI key available", slog.String("reason", reason), slog.Any("error", err), logFail)
+		}
+		return err
+	}
+	changed := !s.ok || s.val.OpenAIKey != val.OpenAIKey
+	s.val, s.source, s.stamp, s.ok = val, source, stamp, true
+	s.mu.Unlock()
+
+	if !changed {
+		logDebug("secrets", "OpenAI key unchanged", slog.String("reason", reason))
+		return nil
+	}
+	resetLLMProviders("openai")
+	logInfo("secrets", "Loaded OpenAI key",
+		slog.String("fingerprint", keyFingerprint(val.OpenAIKey)), slog.String("source", source), slog.String("reason", reason), logOK)
+	return nil
+}
+
+// changed reports whether the secrets file differs from the one last loaded.
+func (s *secretStore) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return stampOf(secretsPath()) != s.stamp
+}
+
+// ReloadSecrets re-reads the OpenAI key now, e.g. on SIGHUP.
+func ReloadSecrets(reason string) {
+	_ = secrets.reload(reason)
+}
+
+// WatchSecrets reloads the OpenAI key whenever the secrets file changes,
+// checking every interval. It returns immediately; 0 disables the check.
+func WatchSecrets(interval time.Duration) {
+	if stampOf(secretsPath()) != (fileStamp{}) {
+		ReloadSecrets("startup")
+	}
+	go watchFiles(interval, secrets.changed, func() { ReloadSecrets("file changed") })
+}
+
+// keyFingerprint identifies a key in logs without revealing it.
+func keyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:4])
+}
+
+// ---- File change detection ----
+
+// fileStamp identifies a version of a file; the zero value means missing.
+type fileStamp struct {
+	ModTime time.Time
+	Size    int64
+}
+
+func stampOf(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{ModTime: fi.ModTime(), Size: fi.Size()}
+}
+
+// watchFiles calls reload each time changed reports true, polling every
+// interval. It never returns; 0 or less returns at once.
+func watchFiles(interval time.Duration, changed func() bool, reload func()) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if changed() {
+			reload()
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// =====================================================
+//                     HTTP server
+// =====================================================
+//
+//	LISTEN_MODE     tls (default) serves HTTPS from TLS_CERT_FILE/TLS_KEY_FILE;
+//	                http serves plain HTTP, e.g. behind a TLS-terminating proxy
+//	REDIRECT_ADDR   tls mode only: also listen here (e.g. :80) and redirect
+//	                every request to HTTPS
+//
+// Behind a proxy, list it in TRUSTED_PROXIES so client IPs and the original
+// scheme come from X-Forwarded-For / X-Forwarded-Proto.
+
+// NewHandler builds the whole API for cfg: routes, auth, rate limits,
+// metrics, tracing, CORS and request IDs. It does not open the job store or
+// resume jobs, so tests can mount it on httptest with the in-memory store.
+func NewHandler(cfg *Config) http.Handler {
+	mux := http.NewServeMux()
+	// Plan limits and rate limits apply per member; anonymous callers are
+	// Free and rate limited by IP. Each route is traced and counted.
+	route := func(path string, auth AuthMode, rate RateClass, h http.HandlerFunc) {
+		mux.Handle(path, WithTracing(path, WithMetrics(path, WithAuth(auth, WithRateLimit(rate, h)))))
+	}
+	route("/CollegeAdvisor", AuthOptional, RateAdvisor, Advisor)
+	route("/CollegeFetch", AuthOptional, RatePoll, Advisor_Fetch)
+	route("/CollegeStream", AuthOptional, RatePoll, Advisor_Stream)
+	route("/CollegeCancel", AuthOptional, RatePoll, CancelJob)
+	route("/CollegeAdvisorDetails", AuthOptional, RateDetails, SchoolDetails)
+	route("/CollegeAdvisorDetailsStatus", AuthOptional, RatePoll, SchoolDetailsStatus)
+
+	// Operator endpoints; disabled unless ADMIN_TOKEN is set.
+	mux.Handle("/admin/jobs", WithAdmin(Admin_Jobs))
+	mux.Handle("/admin/latency", WithAdmin(Admin_Latency))
+	mux.Handle("/admin/usage", WithAdmin(Admin_Usage))
+	mux.Handle("/admin/cache/", WithAdmin(Admin_Cache))
+	mux.Handle("/admin/details/refresh", WithAdmin(Admin_RefreshDetails))
+
+	mux.HandleFunc("/metrics", Metrics)
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", Readyz)
+	mux.HandleFunc("/version", VersionInfo)
+
+	// Wrap mux with CORS; every request gets an X-Request-ID and an access log line
+	return WithRequestID(withCORS(cfg.CORS.Origins, mux))
+}
+
+// NewServer returns the API server for cfg, not yet listening.
+func NewServer(cfg *Config, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Listen.Addr,
+		Handler:           h,
+		ReadHeaderTimeout: time.Duration(cfg.Timeouts.ReadHeader),
+		IdleTimeout:       time.Duration(cfg.Timeouts.Idle),
+	}
+}
+
+// NewRedirectServer returns the HTTP-to-HTTPS redirect server, or nil when
+// cfg has no redirect listener.
+func NewRedirectServer(cfg *Config) *http.Server {
+	if cfg.Listen.Mode != ListenTLS || cfg.Listen.RedirectAddr == "" {
+		return nil
+	}
+	_, port, _ := net.SplitHostPort(cfg.Listen.Addr)
+	return &http.Server{
+		Addr:              cfg.Listen.RedirectAddr,
+		Handler:           redirectToHTTPS(port),
+		ReadHeaderTimeout: time.Duration(cfg.Timeouts.ReadHeader),
+		IdleTimeout:       time.Duration(cfg.Timeouts.Idle),
+	}
+}
+
+// redirectToHTTPS sends every request to the same host and path over HTTPS
+// on port (omitted when 443). 308 keeps the method and body of POSTs.
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "missing Host header", http.StatusBadRequest)
+			return
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+func withCORS(allowedOrigins []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		for _, o := range allowedOrigins {
+			if o == origin {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				// If you need cookies/authorization across origins, enable credentials
+				// w.Header().Set("Access-Control-Allow-Credentials", "true")
+				break
+			}
+		}
+		w.Header().Set("Vary", "Origin") // tells caches the response varies by Origin
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
+		// Ensure common headers are allowed even if the browser doesn't send Access-Control-Request-Headers
+		reqHeaders := r.Header.Get("Access-Control-Request-Headers")
+		allowHeaders := "Content-Type"
+		if reqHeaders != "" {
+			allowHeaders = allowHeaders + ", " + reqHeaders
+		}
+		w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+		// Let the frontend read throttling hints on cross-origin responses
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After, X-Request-ID, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestServer serves NewHandler with the fake LLM provider and the
+// in-memory job store. Caches, ledgers and stats land in a temp directory,
+// and rate limits and plan usage start fresh.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	t.Cleanup(func() { waitJobQueuesIdle(t) })
+	rateLimitersMu.Lock()
+	rateLimiters = make(map[RateClass]*rateLimiter)
+	rateLimitersMu.Unlock()
+	planUsage = newPlanUsageStore(planUsagePath)
+
+	cfg := testConfig()
+	cfg.Models.Provider = "fake"
+	cfg.Jobs.Store = "memory"
+	cfg.Logging.Level = "error"
+	cfg.Apply()
+	if err := OpenJobStore(cfg.Jobs); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewHandler(&cfg))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// waitJobQueuesIdle waits for the workers to finish what they are running,
+// so a job from one test cannot touch the store or files of the next.
+func waitJobQueuesIdle(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for _, kind := range []JobKind{JobKindAdvisor, JobKindDetails} {
+		for {
+			pending, running := queueFor(kind).stats()
+			if pending == 0 && running == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s queue still busy after 10s: %d pending, %d running", kind, pending, running)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+// testProfile is a valid advisor request.
+var testProfile = map[string]any{
+	"school_amount":        "3",
+	"gpa":                  "3.8",
+	"start_year":           "2027",
+	"will_apply_aid":       "Yes",
+	"scholarship_interest": "both",
+	"intended_major":       "Computer Science",
+}
+
+// postJSON posts body to path and decodes the JSON reply into out.
+func postJSON(t *testing.T, srv *httptest.Server, path string, body, out any) *http.Response {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Post(srv.URL+path, "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatalf("POST %s: decoding reply: %v", path, err)
+	}
+	return resp
+}
+
+// fetchedJob is the /CollegeFetch reply.
+type fetchedJob struct {
+	Success string   `json:"success"`
+	State   JobState `json:"state"`
+}
+
+// fetchUntilFinal polls /CollegeFetch until the advisor job id is final.
+func fetchUntilFinal(t *testing.T, srv *httptest.Server, id string) fetchedJob {
+	t.Helper()
+	var fetched fetchedJob
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		postJSON(t, srv, "/CollegeFetch", map[string]string{"id": id}, &fetched)
+		if fetched.State.Final() {
+			return fetched
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("fetch %s: job still %s after 10s", id, fetched.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAdvisorSubmitFetchCancel(t *testing.T) {
+	srv := newTestServer(t)
+
+	// Submit.
+	var submitted struct {
+		ID string `json:"id"`
+	}
+	resp := postJSON(t, srv, "/CollegeAdvisor", testProfile, &submitted)
+	if resp.StatusCode != http.StatusOK || submitted.ID == "" {
+		t.Fatalf("submit: status %d, id %q", resp.StatusCode, submitted.ID)
+	}
+	if resp.Header.Get("X-Request-ID") == "" {
+		t.Error("submit: no X-Request-ID in the response")
+	}
+
+	// Fetch until the job is done.
+	fetched := fetchUntilFinal(t, srv, submitted.ID)
+	if fetched.State != JobSucceeded {
+		t.Fatalf("fetch: state %s (%s), want succeeded", fetched.State, fetched.Success)
+	}
+	var result AdvisorResult
+	if err := json.Unmarshal([]byte(fetched.Success), &result); err != nil {
+		t.Fatalf("fetch: result is not the schools contract: %v", err)
+	}
+	if len(result.Schools) != 3 {
+		t.Errorf("fetch: %d school(s), want 3", len(result.Schools))
+	}
+
+	// Cancel: the job already finished, so nothing changes.
+	var cancelled struct {
+		Cancelled bool     `json:"cancelled"`
+		State     JobState `json:"state"`
+	}
+	resp = postJSON(t, srv, "/CollegeCancel", map[string]string{"id": submitted.ID}, &cancelled)
+	if resp.StatusCode != http.StatusOK || cancelled.Cancelled || cancelled.State != JobSucceeded {
+		t.Errorf("cancel: status %d, %+v; want 200, not cancelled, succeeded", resp.StatusCode, cancelled)
+	}
+
+	var failed map[string]string
+	resp = postJSON(t, srv, "/CollegeCancel", map[string]string{"id": "no-such-job"}, &failed)
+	if resp.StatusCode != http.StatusBadRequest || failed["error"] != "invalid ID" {
+		t.Errorf("cancel unknown: status %d, %v; want 400 invalid ID", resp.StatusCode, failed)
+	}
+}
+
+func TestAdvisorSubmitInvalid(t *testing.T) {
+	srv := newTestServer(t)
+
+	var reply errorResponse
+	resp := postJSON(t, srv, "/CollegeAdvisor", map[string]any{"gpa": "7"}, &reply)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", resp.StatusCode)
+	}
+	for _, field := range []string{"GPA", "School Amount", "Start Year"} {
+		if reply.InvalidFields[field] == "" {
+			t.Errorf("no invalid_fields entry for %s in %v", field, reply.InvalidFields)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// =====================================================
+//                  Graceful shutdown
+// =====================================================
+//
+// On SIGTERM/SIGINT the server drains before exiting: new advisor and details
+// jobs are refused with 503 + Retry-After (cached results and job polls keep
+// working), workers stop taking queued jobs, and running jobs get up to
+// SHUTDOWN_TIMEOUT (default 2m) to finish and save their results. What
+// happens to jobs still queued or running at the deadline depends on the job
+// store: with JOB_STORE=file they stay on disk and resume on the next start;
+// with JOB_STORE=memory they are lost and clients polling them get "invalid ID".
+
+// drainRetryAfter is what refused clients are told to wait, roughly a restart.
+const drainRetryAfter = 30
+
+var draining atomic.Bool
+
+// isDraining reports whether the server is shutting down.
+func isDraining() bool { return draining.Load() }
+
+// DrainJobs stops new jobs from starting and waits until running jobs finish
+// or ctx is done. It reports whether every running job finished.
+func DrainJobs(ctx context.Context) bool {
+	draining.Store(true)
+	startJobQueues()
+
+	queued, running := drainStats()
+	fate := "lost (JOB_STORE=memory)"
+	if jobsResumable() {
+		fate = "resume on next start"
+	}
+	logInfo("DrainJobs", "Draining", slog.Int("running", running), slog.Int("queued", queued), slog.String("unfinished", fate))
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	lastLog := time.Now()
+	for running > 0 {
+		select {
+		case <-ctx.Done():
+			logWarn("DrainJobs", "Deadline reached with jobs still running",
+				slog.Int("running", running), slog.String("unfinished", fate), logFail)
+			return false
+		case <-ticker.C:
+		}
+		_, running = drainStats()
+		if running > 0 && time.Since(lastLog) >= 10*time.Second {
+			logInfo("DrainJobs", "Waiting for running jobs", slog.Int("running", running))
+			lastLog = time.Now()
+		}
+	}
+	logInfo("DrainJobs", "All running jobs finished", logOK)
+	return true
+}
+
+// jobsResumable reports whether unfinished jobs survive a restart.
+func jobsResumable() bool {
+	_, ok := jobs.(*fileJobStore)
+	return ok
+}
+
+func drainStats() (queued, running int) {
+	for _, q := range jobQueues {
+		p, r := q.stats()
+		queued += p
+		running += r
+	}
+	return queued, running
+}
+
+// FlushState writes out in-memory state that may not be on disk yet: latency
+// statistics and buffered trace spans. Nothing else needs flushing: workers
+// write the response and details caches synchronously before marking a job
+// succeeded, and the spend ledger, plan usage and member usage are saved on
+// every change, so all of them are on disk once running jobs have finished.
+func FlushState(ctx context.Context) {
+	AdvisorLatency.save()
+	DetailsLatency.save()
+	FlushTraces(ctx)
+	logInfo("FlushState", "Latency stats and traces flushed", logOK)
+}
+
+// writeDraining refuses a new job while the server shuts down.
+func writeDraining(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(drainRetryAfter))
+	writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+		"error":       "The server is restarting. Please try again in a moment.",
+		"code":        "draining",
+		"retry_after": drainRetryAfter,
+	})
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// undrainAfter ends draining when the test does, or earlier through the
+// returned func, and wakes the workers so queued jobs start again.
+func undrainAfter(t *testing.T) (undrain func()) {
+	undrain = func() {
+		draining.Store(false)
+		for _, q := range jobQueues {
+			q.mu.Lock()
+			q.cond.Broadcast()
+			q.mu.Unlock()
+		}
+	}
+	t.Cleanup(undrain)
+	return undrain
+}
+
+func TestDrainJobs(t *testing.T) {
+	srv, started := newBlockingServer(t)
+	undrain := undrainAfter(t)
+
+	_, reply := submitAdvisor(t, srv, "3.1", "")
+	running, _ := reply["id"].(string)
+	waitStarted(t, started)
+
+	drained := make(chan bool, 1)
+	go func() { drained <- DrainJobs(context.Background()) }()
+	for !isDraining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// New jobs are refused.
+	var refused struct {
+		Code       string `json:"code"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	resp := postJSON(t, srv, "/CollegeAdvisor", testProfile, &refused)
+	if resp.StatusCode != http.StatusServiceUnavailable || refused.Code != "draining" {
+		t.Errorf("submit while draining: status %d, %+v; want 503 draining", resp.StatusCode, refused)
+	}
+	if got := resp.Header.Get("Retry-After"); got != strconv.Itoa(drainRetryAfter) || refused.RetryAfter != drainRetryAfter {
+		t.Errorf("Retry-After %q, retry_after %d; want %d", got, refused.RetryAfter, drainRetryAfter)
+	}
+	ready, err := srv.Client().Get(srv.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready.Body.Close()
+	if ready.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("/readyz while draining: status %d, want 503", ready.StatusCode)
+	}
+
+	// Polls still work, and a queued job waits instead of starting.
+	var fetched fetchedJob
+	postJSON(t, srv, "/CollegeFetch", map[string]string{"id": running}, &fetched)
+	if fetched.State != JobRunning {
+		t.Errorf("poll while draining: job is %s, want running", fetched.State)
+	}
+	queued := newJob("job-queued-while-draining", JobKindAdvisor, "drain-key", testProfile)
+	if err := jobs.Create(queued); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enqueueJob(queued, true); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+		t.Fatal("a queued job started while draining")
+	case ok := <-drained:
+		t.Fatalf("DrainJobs returned %v with a job still running", ok)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// Drain ends once the running job does.
+	cancelJob(running)
+	select {
+	case ok := <-drained:
+		if !ok {
+			t.Error("DrainJobs = false, want true once every running job finished")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("DrainJobs did not return within 10s of the last job finishing")
+	}
+	if j, _ := jobs.Get(queued.ID); j.State != JobQueued {
+		t.Errorf("queued job is %s after the drain, want still queued", j.State)
+	}
+
+	// On the next start the queued job runs.
+	undrain()
+	waitStarted(t, started)
+}
+
+func TestDrainJobsDeadline(t *testing.T) {
+	srv, started := newBlockingServer(t)
+	undrainAfter(t)
+
+	submitAdvisor(t, srv, "3.1", "")
+	waitStarted(t, started)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if DrainJobs(ctx) {
+		t.Error("DrainJobs = true with a job still running at the deadline")
+	}
+}
@@ -0,0 +1,595 @@
+package handlers
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =====================================================
+//                       Tracing
+// =====================================================
+//
+// A small OpenTelemetry-compatible tracer: spans for every routed handler,
+// cache lookups, the job lifecycle (queued, run) and each LLM call and
+// attempt. W3C traceparent headers are honoured, jobs remember the span of
+// the POST that created them, and polls for a job link back to it.
+//
+//	OTEL_TRACES_EXPORTER                 none (default) | stdout | otlp
+//	OTEL_EXPORTER_OTLP_ENDPOINT          collector base URL (default http://localhost:4318)
+//	OTEL_EXPORTER_OTLP_TRACES_ENDPOINT   full traces URL, overrides the above
+//	OTEL_EXPORTER_OTLP_HEADERS           extra headers, "key=value,key2=value2"
+//	OTEL_SERVICE_NAME                    service.name resource (default aidvisor-backend)
+//
+// or the matching keys in the tracing section of the config.
+//
+// The stdout exporter writes one JSON object per span; the otlp exporter
+// POSTs OTLP/HTTP JSON to <endpoint>/v1/traces.
+
+type spanKind int
+
+// OTLP span kinds.
+const (
+	spanInternal spanKind = 1
+	spanServer   spanKind = 2
+	spanClient   spanKind = 3
+)
+
+type traceID [16]byte
+type spanID [8]byte
+
+// spanContext identifies a span across process and job boundaries.
+type spanContext struct {
+	TraceID traceID
+	SpanID  spanID
+}
+
+func (sc spanContext) valid() bool { return sc.TraceID != traceID{} && sc.SpanID != spanID{} }
+
+// traceparent formats sc as a W3C traceparent header value.
+func (sc spanContext) traceparent() string {
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-01"
+}
+
+// parseTraceparent reads a W3C traceparent header value.
+func parseTraceparent(v string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	return sc, sc.valid()
+}
+
+type spanAttr struct {
+	Key   string
+	Value any
+}
+
+// span is one timed operation. A nil *span (tracing disabled) ignores every
+// call, so callers never need to check.
+type span struct {
+	ctx    spanContext
+	parent spanID
+	name   string
+	kind   spanKind
+	start  time.Time
+
+	mu      sync.Mutex
+	end     time.Time
+	attrs   []spanAttr
+	links   []spanContext
+	failed  bool
+	message string
+}
+
+type spanContextKey struct{}
+
+// startSpan starts a child of the span in ctx (or a new trace) and returns a
+// context carrying it.
+func startSpan(ctx context.Context, name string, kind spanKind, attrs ...spanAttr) (context.Context, *span) {
+	return startSpanAt(ctx, name, kind, time.Now(), attrs...)
+}
+
+func startSpanAt(ctx context.Context, name string, kind spanKind, start time.Time, attrs ...spanAttr) (context.Context, *span) {
+	if tracer() == nil {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: kind, start: start, attrs: attrs}
+	if parent, ok := ctx.Value(spanContextKey{}).(spanContext); ok {
+		s.ctx.TraceID = parent.TraceID
+		s.parent = parent.SpanID
+	} else {
+		_, _ = rand.Read(s.ctx.TraceID[:])
+	}
+	_, _ = rand.Read(s.ctx.SpanID[:])
+	return contextWithSpan(ctx, s), s
+}
+
+type activeSpanKey struct{}
+
+// contextWithSpan makes s the parent of spans started from the returned ctx.
+func contextWithSpan(ctx context.Context, s *span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(context.WithValue(ctx, spanContextKey{}, s.ctx), activeSpanKey{}, s)
+}
+
+// spanFromContext returns the span started for ctx, or nil.
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(activeSpanKey{}).(*span)
+	return s
+}
+
+func attr(key string, value any) spanAttr { return spanAttr{Key: key, Value: value} }
+
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].Key == key {
+			s.attrs[i].Value = value
+			return
+		}
+	}
+	s.attrs = append(s.attrs, spanAttr{key, value})
+}
+
+func (s *span) link(sc spanContext) {
+	if s == nil || !sc.valid() {
+		return
+	}
+	s.mu.Lock()
+	s.links = append(s.links, sc)
+	s.mu.Unlock()
+}
+
+// fail marks the span as errored.
+func (s *span) fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed, s.message = true, err.Error()
+	s.mu.Unlock()
+}
+
+// finish ends the span and hands it to the exporter. Only the first call counts.
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	tracer().export(s)
+}
+
+// traceparent returns the W3C header value for the span in ctx, or "".
+func traceparent(ctx context.Context) string {
+	if s := spanFromContext(ctx); s != nil {
+		return s.ctx.traceparent()
+	}
+	return ""
+}
+
+// ---- Exporter ----
+
+const (
+	traceBatchSize     = 512
+	traceQueueSize     = 2048
+	traceFlushInterval = 5 * time.Second
+)
+
+type traceExporter struct {
+	kind     string // stdout | otlp
+	endpoint string
+	headers  map[string]string
+	service  string
+	out      io.Writer
+	client   *http.Client
+
+	queue   chan *span
+	flushes chan chan struct{}
+
+	mu      sync.Mutex
+	dropped int64
+}
+
+var (
+	tracerOnce sync.Once
+	tracerExp  *traceExporter
+
+	// tracingConfig is the tracing section of the config, set by
+	// configureTracing.
+	tracingConfig = TracingConfig{Exporter: "none", Endpoint: "http://localhost:4318", ServiceName: "aidvisor-backend"}
+)
+
+// configureTracing sets up tracing. The exporter is built on first use, so
+// this must run before serving requests.
+func configureTracing(c TracingConfig) {
+	tracingConfig = c
+}
+
+// tracer returns the configured exporter, or nil when tracing is off.
+func tracer() *traceExporter {
+	tracerOnce.Do(func() {
+		tracerExp = newTraceExporter(tracingConfig)
+	})
+	return tracerExp
+}
+
+// newTraceExporter starts an exporter for c, or returns nil for "none".
+func newTraceExporter(c TracingConfig) *traceExporter {
+	kind := strings.ToLower(strings.TrimSpace(c.Exporter))
+	switch kind {
+	case "", "none":
+		return nil
+	case "stdout", "console":
+		kind = "stdout"
+	case "otlp":
+	default:
+		logWarn("tracer", "Unknown exporter, tracing disabled", slog.String("exporter", kind))
+		return nil
+	}
+
+	e := &traceExporter{
+		kind:     kind,
+		service:  cmp.Or(strings.TrimSpace(c.ServiceName), "aidvisor-backend"),
+		endpoint: strings.TrimSpace(c.TracesEndpoint),
+		out:      os.Stdout,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		headers:  map[string]string{},
+		queue:    make(chan *span, traceQueueSize),
+		flushes:  make(chan chan struct{}),
+	}
+	if e.endpoint == "" {
+		base := cmp.Or(strings.TrimSpace(c.Endpoint), "http://localhost:4318")
+		e.endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+	}
+	for _, kv := range strings.Split(c.Headers, ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.TrimSpace(k) != "" {
+			e.headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+
+	go e.run()
+	if kind == "otlp" {
+		logInfo("tracer", "Exporting traces", slog.String("endpoint", e.endpoint), slog.String("service", e.service))
+	} else {
+		logInfo("tracer", "Exporting traces to stdout", slog.String("service", e.service))
+	}
+	return e
+}
+
+func (e *traceExporter) export(s *span) {
+	select {
+	case e.queue <- s:
+	default:
+		e.mu.Lock()
+		e.dropped++
+		e.mu.Unlock()
+	}
+}
+
+func (e *traceExporter) run() {
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	var batch []*span
+	send := func() {
+		if len(batch) > 0 {
+			e.write(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= traceBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-e.flushes:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			send()
+			close(done)
+		}
+	}
+}
+
+// FlushTraces exports spans that are still buffered, waiting until ctx ends.
+func FlushTraces(ctx context.Context) {
+	e := tracer()
+	if e == nil {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case e.flushes <- done:
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+func (e *traceExporter) write(batch []*span) {
+	e.mu.Lock()
+	dropped := e.dropped
+	e.dropped = 0
+	e.mu.Unlock()
+	if dropped > 0 {
+		logWarn("tracer", "Dropped spans: export queue full", slog.Int64("spans", dropped))
+	}
+
+	if e.kind == "stdout" {
+		enc := json.NewEncoder(e.out)
+		for _, s := range batch {
+			_ = enc.Encode(s.stdoutJSON())
+		}
+		return
+	}
+
+	body, err := json.Marshal(e.otlpJSON(batch))
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		logWarn("tracer", "Bad OTLP endpoint", slog.String("endpoint", e.endpoint), slog.Any("error", err), logFail)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		logWarn("tracer", "Failed to export spans", slog.Int("spans", len(batch)), slog.Any("error", err), logFail)
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		logWarn("tracer", "Collector rejected spans", slog.Int("spans", len(batch)), slog.String("status", resp.Status), logFail)
+	}
+}
+
+// stdoutJSON is a readable one-line rendering of a finished span.
+func (s *span) stdoutJSON() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attrs := make(map[string]any, len(s.attrs))
+	for _, a := range s.attrs {
+		attrs[a.Key] = a.Value
+	}
+	links := make([]string, 0, len(s.links))
+	for _, l := range s.links {
+		links = append(links, l.traceparent())
+	}
+	out := map[string]any{
+		"trace_id":    hex.EncodeToString(s.ctx.TraceID[:]),
+		"span_id":     hex.EncodeToString(s.ctx.SpanID[:]),
+		"name":        s.name,
+		"kind":        map[spanKind]string{spanInternal: "internal", spanServer: "server", spanClient: "client"}[s.kind],
+		"start":       s.start.UTC().Format(time.RFC3339Nano),
+		"duration_ms": float64(s.end.Sub(s.start).Microseconds()) / 1000,
+		"attributes":  attrs,
+		"status":      "ok",
+	}
+	if s.parent != (spanID{}) {
+		out["parent_span_id"] = hex.EncodeToString(s.parent[:])
+	}
+	if len(links) > 0 {
+		out["links"] = links
+	}
+	if s.failed {
+		out["status"] = "error"
+		out["error"] = s.message
+	}
+	return out
+}
+
+// otlpJSON builds an OTLP/HTTP JSON ExportTraceServiceRequest.
+func (e *traceExporter) otlpJSON(batch []*span) map[string]any {
+	spans := make([]map[string]any, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		o := map[string]any{
+			"traceId":           hex.EncodeToString(s.ctx.TraceID[:]),
+			"spanId":            hex.EncodeToString(s.ctx.SpanID[:]),
+			"name":              s.name,
+			"kind":              int(s.kind),
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttrs(s.attrs),
+			"status":            map[string]any{"code": 1},
+		}
+		if s.parent != (spanID{}) {
+			o["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if len(s.links) > 0 {
+			links := make([]map[string]any, 0, len(s.links))
+			for _, l := range s.links {
+				links = append(links, map[string]any{
+					"traceId": hex.EncodeToString(l.TraceID[:]),
+					"spanId":  hex.EncodeToString(l.SpanID[:]),
+				})
+			}
+			o["links"] = links
+		}
+		if s.failed {
+			o["status"] = map[string]any{"code": 2, "message": s.message}
+		}
+		s.mu.Unlock()
+		spans = append(spans, o)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttrs([]spanAttr{attr("service.name", e.service)}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "backend/handlers"},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+func otlpAttrs(attrs []spanAttr) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for _, a := range attrs {
+		var v map[string]any
+		switch x := a.Value.(type) {
+		case string:
+			v = map[string]any{"stringValue": x}
+		case bool:
+			v = map[string]any{"boolValue": x}
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(x)}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+		case float64:
+			v = map[string]any{"doubleValue": x}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(x)}
+		}
+		out = append(out, map[string]any{"key": a.Key, "value": v})
+	}
+	return out
+}
+
+// ---- HTTP ----
+
+// WithTracing starts a server span for each request under route, continuing
+// the caller's trace when it sends a traceparent header.
+func WithTracing(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracer() == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if sc, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = context.WithValue(ctx, spanContextKey{}, sc)
+		}
+		ctx, s := startSpan(ctx, r.Method+" "+route, spanServer,
+			attr("http.request.method", r.Method),
+			attr("http.route", route),
+			attr("url.path", r.URL.Path),
+			attr("url.scheme", requestScheme(r)),
+			attr("client.address", clientIP(r)),
+		)
+		if rid := requestID(r); rid != "" {
+			s.set("request_id", rid)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		s.set("http.response.status_code", rec.status)
+		if rec.status >= 500 {
+			s.fail(fmt.Errorf("HTTP %d", rec.status))
+		}
+		s.finish()
+	})
+}
+
+// traceJobPoll tags the request's span with the job it asks about and links
+// it to the span of the request that created the job.
+func traceJobPoll(r *http.Request, job Job) {
+	s := spanFromContext(r.Context())
+	if s == nil {
+		return
+	}
+	s.set("job.id", job.ID)
+	s.set("job.kind", string(job.Kind))
+	s.set("job.state", string(job.State))
+	if sc, ok := parseTraceparent(job.TraceParent); ok {
+		s.link(sc)
+	}
+}
+
+// ---- Jobs ----
+
+// runningJobSpans holds the run span of each job a worker is executing, so
+// jobContext can parent the job's LLM spans under it.
+var runningJobSpans sync.Map
+
+// traceJobRun records the time a job spent queued and starts its run span,
+// both children of the request that created the job. The returned func ends
+// the run span with the job's final state.
+func traceJobRun(job Job) func() {
+	if tracer() == nil {
+		return func() {}
+	}
+	ctx := context.Background()
+	if sc, ok := parseTraceparent(job.TraceParent); ok {
+		ctx = context.WithValue(ctx, spanContextKey{}, sc)
+	}
+	jobAttrs := []spanAttr{attr("job.id", job.ID), attr("job.kind", string(job.Kind))}
+	if job.RequestID != "" {
+		jobAttrs = append(jobAttrs, attr("request_id", job.RequestID))
+	}
+
+	_, queued := startSpanAt(ctx, "job.queued", spanInternal, job.CreatedAt, jobAttrs...)
+	queued.finish()
+
+	_, run := startSpan(ctx, "job.run", spanInternal, jobAttrs...)
+	runningJobSpans.Store(job.ID, run)
+	return func() {
+		runningJobSpans.Delete(job.ID)
+		if j, ok := jobs.Get(job.ID); ok {
+			run.set("job.state", string(j.State))
+			run.set("job.attempts", j.Attempts)
+			run.set("job.cost_usd", j.CostUSD)
+			if j.State == JobFailed {
+				run.fail(fmt.Errorf("%s", j.Error))
+			}
+		}
+		run.finish()
+	}
+}
+
+// withJobSpan parents ctx under the run span of job id, if it is traced.
+func withJobSpan(ctx context.Context, id string) context.Context {
+	if s, ok := runningJobSpans.Load(id); ok {
+		return contextWithSpan(ctx, s.(*span))
+	}
+	return ctx
+}
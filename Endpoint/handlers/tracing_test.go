@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		trace = "4bf92f3577b34da6a3ce929d0e0e4736"
+		span  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name   string
+		in     string
+		wantOK bool
+	}{
+		{"sampled", "00-" + trace + "-" + span + "-01", true},
+		{"not sampled", "00-" + trace + "-" + span + "-00", true},
+		{"surrounding space", " 00-" + trace + "-" + span + "-01 ", true},
+		{"future version", "cc-" + trace + "-" + span + "-01", true},
+		{"invalid version ff", "ff-" + trace + "-" + span + "-01", false},
+		{"zero trace id", "00-00000000000000000000000000000000-" + span + "-01", false},
+		{"zero span id", "00-" + trace + "-0000000000000000-01", false},
+		{"short trace id", "00-" + trace[:30] + "-" + span + "-01", false},
+		{"long span id", "00-" + trace + "-" + span + "00-01", false},
+		{"not hex", "00-" + trace[:31] + "x-" + span + "-01", false},
+		{"missing flags", "00-" + trace + "-" + span, false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := parseTraceparent(tt.in)
+			if ok != tt.wantOK {
+				t.Fatalf("parseTraceparent(%q) ok = %v, want %v", tt.in, ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got := sc.traceparent(); got != "00-"+trace+"-"+span+"-01" {
+				t.Errorf("round trip = %q", got)
+			}
+		})
+	}
+}
+
+// TestOTLPExport posts two spans to a fake collector and checks the body
+// against the OTLP/HTTP JSON encoding: hex ids, string nanosecond times,
+// int64 attribute values as strings and enum kinds and status codes.
+func TestOTLPExport(t *testing.T) {
+	var (
+		body   []byte
+		header http.Header
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header.Clone()
+	}))
+	defer collector.Close()
+
+	e := newTraceExporter(TracingConfig{
+		Exporter:       "otlp",
+		TracesEndpoint: collector.URL + "/v1/traces",
+		Headers:        "x-api-key=secret",
+		ServiceName:    "test-service",
+	})
+	start := time.Unix(1700000000, 123456789)
+	root := &span{name: "POST /CollegeAdvisor", kind: spanServer, start: start, end: start.Add(time.Second),
+		attrs: []spanAttr{
+			attr("http.route", "/CollegeAdvisor"),
+			attr("http.status_code", 200),
+			attr("llm.tokens", int64(1500)),
+			attr("cache.hit", false),
+			attr("llm.cost_usd", 0.25),
+		}}
+	root.ctx.TraceID, root.ctx.SpanID = traceID{1, 2, 3}, spanID{4, 5, 6}
+	child := &span{ctx: spanContext{TraceID: root.ctx.TraceID, SpanID: spanID{7}}, parent: root.ctx.SpanID,
+		name: "llm.complete", kind: spanClient, start: start, end: start.Add(time.Millisecond)}
+	child.link(spanContext{TraceID: traceID{9}, SpanID: spanID{9}})
+	child.fail(errors.New("429: rate_limit_exceeded"))
+	e.write([]*span{root, child})
+
+	if ct := header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if got := header.Get("x-api-key"); got != "secret" {
+		t.Errorf("x-api-key header = %q, want secret", got)
+	}
+
+	type anyValue struct {
+		StringValue *string  `json:"stringValue"`
+		IntValue    *string  `json:"intValue"`
+		BoolValue   *bool    `json:"boolValue"`
+		DoubleValue *float64 `json:"doubleValue"`
+	}
+	type keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	type otlpSpan struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []keyValue `json:"attributes"`
+		Links        []struct {
+			TraceID string `json:"traceId"`
+			SpanID  string `json:"spanId"`
+		} `json:"links"`
+		Status struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"status"`
+	}
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []keyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Scope struct {
+					Name string `json:"name"`
+				} `json:"scope"`
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		t.Fatalf("collector got %s: %v", body, err)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("want one resource with one scope, got %s", body)
+	}
+	rs := req.ResourceSpans[0]
+	if a := rs.Resource.Attributes; len(a) != 1 || a[0].Key != "service.name" ||
+		a[0].Value.StringValue == nil || *a[0].Value.StringValue != "test-service" {
+		t.Errorf("resource attributes = %+v, want service.name test-service", a)
+	}
+	if rs.ScopeSpans[0].Scope.Name == "" {
+		t.Error("scope has no name")
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d span(s), want 2", len(spans))
+	}
+
+	isHex := func(s string, n int) bool {
+		_, err := hex.DecodeString(s)
+		return err == nil && len(s) == n
+	}
+	for _, s := range spans {
+		if !isHex(s.TraceID, 32) || !isHex(s.SpanID, 16) {
+			t.Errorf("%s: traceId %q / spanId %q, want 32 and 16 hex digits", s.Name, s.TraceID, s.SpanID)
+		}
+		if s.Start != strconv.FormatInt(start.UnixNano(), 10) {
+			t.Errorf("%s: startTimeUnixNano = %q, want %d", s.Name, s.Start, start.UnixNano())
+		}
+		if end, err := strconv.ParseInt(s.End, 10, 64); err != nil || end <= start.UnixNano() {
+			t.Errorf("%s: endTimeUnixNano = %q, want a later time", s.Name, s.End)
+		}
+	}
+
+	r, c := spans[0], spans[1]
+	if r.Kind != 2 || r.Status.Code != 1 || r.ParentSpanID != "" || r.Links != nil {
+		t.Errorf("root span = %+v, want kind 2 (server), status ok, no parent or links", r)
+	}
+	str := func(s string) *string { return &s }
+	values := map[string]anyValue{
+		"http.route":       {StringValue: str("/CollegeAdvisor")},
+		"http.status_code": {IntValue: str("200")},
+		"llm.tokens":       {IntValue: str("1500")},
+		"cache.hit":        {BoolValue: new(bool)},
+		"llm.cost_usd":     {DoubleValue: func() *float64 { f := 0.25; return &f }()},
+	}
+	if len(r.Attributes) != len(values) {
+		t.Errorf("root span has %d attribute(s), want %d", len(r.Attributes), len(values))
+	}
+	for _, a := range r.Attributes {
+		want, ok := values[a.Key]
+		gotJSON, _ := json.Marshal(a.Value)
+		wantJSON, _ := json.Marshal(want)
+		if !ok || string(gotJSON) != string(wantJSON) {
+			t.Errorf("attribute %s = %s, want %s", a.Key, gotJSON, wantJSON)
+		}
+	}
+
+	if c.Kind != 3 || c.TraceID != r.TraceID || c.ParentSpanID != r.SpanID {
+		t.Errorf("child span = %+v, want kind 3 (client) under the root span", c)
+	}
+	if c.Status.Code != 2 || c.Status.Message != "429: rate_limit_exceeded" {
+		t.Errorf("failed span status = %+v, want code 2 with the error", c.Status)
+	}
+	if len(c.Links) != 1 || !isHex(c.Links[0].TraceID, 32) || !isHex(c.Links[0].SpanID, 16) {
+		t.Errorf("links = %+v, want one hex span context", c.Links)
+	}
+}
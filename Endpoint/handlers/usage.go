@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =====================================================
+//              Token usage and cost accounting
+// =====================================================
+//
+// Each finished LLM call is accounted three ways: on the job itself (kept in
+// the job store), per usage key and UTC day in data/llm_usage.json, and in the
+// endpoint's latency stats file.
+
+const llmUsagePath = "data/llm_usage.json"
+
+// usageRetentionDays is how many days of per-member usage are kept.
+const usageRetentionDays = 90
+
+// usageTotals is token usage and cost summed over one or more jobs.
+type usageTotals struct {
+	Jobs int64 `json:"jobs"`
+	LLMUsage
+	CostUSD float64 `json:"cost_usd"`
+}
+
+func (t *usageTotals) add(u LLMUsage, cost float64) {
+	t.Jobs++
+	t.LLMUsage.add(u)
+	t.CostUSD += cost
+}
+
+// memberUsageLedger is usage per UTC day ("2006-01-02") and usage key.
+type memberUsageLedger struct {
+	mu   sync.Mutex
+	path string
+	days map[string]map[string]*usageTotals
+}
+
+var llmUsage = newMemberUsageLedger(llmUsagePath)
+
+func newMemberUsageLedger(path string) *memberUsageLedger {
+	l := &memberUsageLedger{path: path, days: make(map[string]map[string]*usageTotals)}
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &l.days); err != nil {
+			logWarn("llmUsage", "Ignoring unreadable file", slog.String("path", path), slog.Any("error", err))
+			l.days = make(map[string]map[string]*usageTotals)
+		}
+	}
+	return l
+}
+
+func (l *memberUsageLedger) add(key string, u LLMUsage, cost float64, now time.Time) {
+	day, _ := spendPeriods(now)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	members := l.days[day]
+	if members == nil {
+		members = make(map[string]*usageTotals)
+		l.days[day] = members
+	}
+	t := members[key]
+	if t == nil {
+		t = &usageTotals{}
+		members[key] = t
+	}
+	t.add(u, cost)
+
+	cutoff, _ := spendPeriods(now.AddDate(0, 0, -usageRetentionDays))
+	for d := range l.days {
+		if d < cutoff {
+			delete(l.days, d)
+		}
+	}
+	l.save()
+}
+
+// save writes the ledger; callers hold l.mu.
+func (l *memberUsageLedger) save() {
+	err := os.MkdirAll(filepath.Dir(l.path), 0o755)
+	var data []byte
+	if err == nil {
+		data, err = json.MarshalIndent(l.days, "", "  ")
+	}
+	if err == nil {
+		err = writeFileAtomic(l.path, data, 0o644)
+	}
+	if err != nil {
+		logWarn("llmUsage", "Failed to save usage", slog.String("path", l.path), slog.Any("error", err))
+	}
+}
+
+// usageReportDay is one day of a usage report.
+type usageReportDay struct {
+	Day     string                  `json:"day"`
+	Total   usageTotals             `json:"total"`
+	Members map[string]*usageTotals `json:"members,omitempty"`
+}
+
+// report returns the days in [from, to] (inclusive, "2006-01-02"), newest
+// first, optionally restricted to one usage key.
+func (l *memberUsageLedger) report(from, to, key string) []usageReportDay {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := []usageReportDay{}
+	for day, members := range l.days {
+		if day < from || day > to {
+			continue
+		}
+		r := usageReportDay{Day: day, Members: make(map[string]*usageTotals)}
+		for k, t := range members {
+			if key != "" && k != key {
+				continue
+			}
+			c := *t
+			r.Members[k] = &c
+			r.Total.Jobs += t.Jobs
+			r.Total.LLMUsage.add(t.LLMUsage)
+			r.Total.CostUSD += t.CostUSD
+		}
+		if len(r.Members) > 0 {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Day > out[j].Day })
+	return out
+}
+
+// recordJobUsage accounts one job's LLM usage: charges the spend ledger,
+// stores tokens and cost on the job, adds them to the job's member for today
+// and to the endpoint's latency stats.
+func recordJobUsage(id string, model string, usage LLMUsage, latency *latencyStats) {
+	cost := chargeLLM(id, model, usage)
+
+	var member string
+	var kind JobKind
+	jobs.Update(id, func(j *Job) {
+		j.Model = model
+		j.Usage.add(usage)
+		j.CostUSD += cost
+		member, kind = j.Member, j.Kind
+	})
+	if member == "" {
+		member = "unknown"
+	}
+	llmUsage.add(member, usage, cost, time.Now())
+	latency.recordUsage(usage, cost)
+
+	metricLLMTokens.add(float64(usage.PromptTokens), string(kind), model, "prompt")
+	metricLLMTokens.add(float64(usage.CompletionTokens), string(kind), model, "completion")
+	metricLLMTokens.add(float64(usage.ReasoningTokens), string(kind), model, "reasoning")
+	metricLLMCost.add(cost, string(kind), model)
+}
+
+// ---- Admin endpoint ----
+
+// Admin_Usage reports per-member token usage and cost by day.
+//
+//	GET /admin/usage?from=2006-01-02&to=2006-01-02&member=<usage key>
+//
+// from/to default to the last 7 days; member is "member:<id>" or "ip:<addr>".
+func Admin_Usage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.URL.Query()
+	now := time.Now()
+	to, _ := spendPeriods(now)
+	from, _ := spendPeriods(now.AddDate(0, 0, -6))
+	for name, dst := range map[string]*string{"from": &from, "to": &to} {
+		v := strings.TrimSpace(q.Get(name))
+		if v == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "'" + name + "' must be YYYY-MM-DD"})
+			return
+		}
+		*dst = v
+	}
+
+	days := llmUsage.report(from, to, strings.TrimSpace(q.Get("member")))
+	var total usageTotals
+	for _, d := range days {
+		total.Jobs += d.Total.Jobs
+		total.LLMUsage.add(d.Total.LLMUsage)
+		total.CostUSD += d.Total.CostUSD
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"from":  from,
+		"to":    to,
+		"total": total,
+		"days":  days,
+	})
+}
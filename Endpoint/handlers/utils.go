@@ -7,7 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,28 +17,6 @@ import (
 	"time"
 )
 
-var (
-	debug bool = true
-)
-
-func dbgPrintf(format string, args ...any) {
-	if debug {
-		log.Printf("[DEBUG] "+format, args...)
-	}
-}
-
-func warnPrintf(format string, args ...any) {
-	log.Printf("[WARN] "+format, args...)
-}
-
-func errPrintf(format string, args ...any) {
-	log.Printf("[ERROR] "+format, args...)
-}
-
-// func infoPrintf(format string, args ...any) {
-// 	log.Printf("[INFO] "+format, args...)
-// }
-
 // ---- Response cache with checksum ----
 
 type cachedResponse struct {
@@ -46,35 +24,44 @@ type cachedResponse struct {
 	Response  string `json:"response"`
 }
 
-const cacheDir = "data/response_cache"
+// cacheLocation is where a file cache lives and how long entries stay fresh.
+type cacheLocation struct {
+	Dir string
+	TTL time.Duration
+}
+
+// responseCache holds one advisor result per payload checksum. Set from the
+// config at startup.
+var responseCache = cacheLocation{Dir: "data/response_cache", TTL: 7 * 24 * time.Hour}
 
 var cacheCleanupOnce sync.Once
 
 // startCacheCleanup runs a background goroutine that cleans expired cache files weekly
 func startCacheCleanup() {
 	cacheCleanupOnce.Do(func() {
+		cache := responseCache
 		go func() {
 			ticker := time.NewTicker(7 * 24 * time.Hour) // Run every week
 			defer ticker.Stop()
 
 			// Run immediately on startup, then weekly
-			cleanExpiredCache()
+			cleanExpiredCache(cache)
 
 			for range ticker.C {
-				cleanExpiredCache()
+				cleanExpiredCache(cache)
 			}
 		}()
 	})
 }
 
-// cleanExpiredCache removes all cache files older than cacheTTL
-func cleanExpiredCache() {
-	dbgPrintf("[cleanExpiredCache] Starting weekly cache cleanup\n")
+// cleanExpiredCache removes all cache files older than cache.TTL
+func cleanExpiredCache(cache cacheLocation) {
+	logDebug("cleanExpiredCache", "Starting weekly cache cleanup")
 
-	entries, err := os.ReadDir(cacheDir)
+	entries, err := os.ReadDir(cache.Dir)
 	if err != nil {
 		if !os.IsNotExist(err) {
-			warnPrintf("[cleanExpiredCache] Error reading cache directory: %v\n", err)
+			logWarn("cleanExpiredCache", "Error reading cache directory", slog.Any("error", err))
 		}
 		return
 	}
@@ -85,28 +72,28 @@ func cleanExpiredCache() {
 			continue
 		}
 
-		path := filepath.Join(cacheDir, entry.Name())
+		path := filepath.Join(cache.Dir, entry.Name())
 		info, err := entry.Info()
 		if err != nil {
 			continue
 		}
 
 		age := time.Since(info.ModTime())
-		if age > cacheTTL {
+		if age > cache.TTL {
 			if err := os.Remove(path); err == nil {
 				removed++
 			}
 		}
 	}
 
-	dbgPrintf("[cleanExpiredCache] Cleanup complete: removed %d expired file(s)\n", removed)
+	logDebug("cleanExpiredCache", "Cleanup complete", slog.Int("removed", removed))
 }
 
 func getCachedResponse(checksum string) (string, bool) {
 	// Start cleanup routine on first cache access
 	startCacheCleanup()
 
-	path := filepath.Join(cacheDir, checksum+".json")
+	path := filepath.Join(responseCache.Dir, checksum+".json")
 	b, err := os.ReadFile(path)
 	if err != nil {
 		return "", false
@@ -119,7 +106,7 @@ func getCachedResponse(checksum string) (string, bool) {
 
 	// Check if cache is expired
 	age := time.Since(time.Unix(cached.Timestamp, 0))
-	if age > cacheTTL {
+	if age > responseCache.TTL {
 		_ = os.Remove(path)
 		return "", false
 	}
@@ -128,25 +115,23 @@ func getCachedResponse(checksum string) (string, bool) {
 }
 
 func saveCachedResponse(checksum string, response string) {
-	_ = os.MkdirAll(cacheDir, 0o755)
-
 	cached := cachedResponse{
 		Timestamp: time.Now().Unix(),
 		Response:  response,
 	}
 
-	data, err := json.MarshalIndent(cached, "", "  ")
+	path := filepath.Join(responseCache.Dir, checksum+".json")
+	err := os.MkdirAll(responseCache.Dir, 0o755)
+	var data []byte
+	if err == nil {
+		data, err = json.MarshalIndent(cached, "", "  ")
+	}
+	if err == nil {
+		err = writeFileAtomic(path, data, 0o644)
+	}
 	if err != nil {
-		return
+		logWarn("saveCachedResponse", "Failed to cache response", slog.String("path", path), slog.Any("error", err))
 	}
-
-	path := filepath.Join(cacheDir, checksum+".json")
-	_ = os.WriteFile(path, data, 0o644)
-}
-
-func escapeJSON(s string) string {
-	b, _ := json.Marshal(s) // quotes + escapes
-	return string(b[1 : len(b)-1])
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -155,32 +140,6 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
-// ---- In-memory prompt store ----
-
-var promptStore = struct {
-	mu sync.RWMutex
-	m  map[string]string
-}{m: make(map[string]string)}
-
-func savePrompt(id, prompt string) {
-	promptStore.mu.Lock()
-	promptStore.m[id] = prompt
-	promptStore.mu.Unlock()
-}
-
-func getPrompt(id string) (string, bool) {
-	promptStore.mu.RLock()
-	p, ok := promptStore.m[id]
-	promptStore.mu.RUnlock()
-	return p, ok
-}
-
-func deletePrompt(id string) {
-	promptStore.mu.Lock()
-	delete(promptStore.m, id)
-	promptStore.mu.Unlock()
-}
-
 // ---- ID generator (24-char hex) ----
 
 func genID() (string, error) {
@@ -253,30 +212,48 @@ func byteOffsetToLineCol(src []byte, offset int) (line, col int) {
 	return line, col
 }
 
-func getAPIKey() (string, error) {
-	secretsOnce.Do(func() {
-		path := os.Getenv("OPENAI_SECRETS_PATH")
-		if path == "" {
-			path = "data/openai.json"
-		}
-		if b, err := os.ReadFile(path); err == nil {
-			var s secretFile
-			if jerr := json.Unmarshal(b, &s); jerr == nil && strings.TrimSpace(s.OpenAIKey) != "" {
-				secretVal = s
-				return
-			}
-		}
-		env := strings.TrimSpace(os.Getenv("OPENAI_API_KEY"))
-		if env == "" {
-			secretErr = errors.New("Backend Key Issue.")
-			return
-		}
-		secretVal = secretFile{OpenAIKey: env}
-	})
-	if secretErr != nil {
-		return "", secretErr
+// Error classes shared by sanitizeOpenAIError and the error metrics.
+const (
+	errClassFixtureMissing = "fixture_missing"
+	errClassBudget         = "budget"
+	errClassCircuitOpen    = "circuit_open"
+	errClassQuota          = "quota"
+	errClassRateLimit      = "rate_limit"
+	errClassAuth           = "auth"
+	errClassTimeout        = "timeout"
+	errClassInvalidOutput  = "invalid_output"
+	errClassOther          = "other"
+)
+
+// errorClass buckets an LLM error into one of the errClass* categories.
+func errorClass(err error) string {
+	switch {
+	// Fixture replay misses are a local-development concern
+	case errors.Is(err, errFixtureMissing):
+		return errClassFixtureMissing
+	// Our own spend guards
+	case errors.Is(err, errBudgetExceeded):
+		return errClassBudget
+	case errors.Is(err, errCircuitOpen):
+		return errClassCircuitOpen
+	case errors.Is(err, errLLMInvalidOutput):
+		return errClassInvalidOutput
 	}
-	return secretVal.OpenAIKey, nil
+
+	errStr := err.Error()
+	switch {
+	case isQuotaError(err):
+		return errClassQuota
+	case isRateLimitError(err):
+		return errClassRateLimit
+	case strings.Contains(errStr, "invalid_api_key") ||
+		strings.Contains(errStr, "Incorrect API key"):
+		return errClassAuth
+	case strings.Contains(errStr, "timeout") ||
+		strings.Contains(errStr, "deadline exceeded"):
+		return errClassTimeout
+	}
+	return errClassOther
 }
 
 // sanitizeOpenAIError returns a user-friendly error message
@@ -286,30 +263,19 @@ func sanitizeOpenAIError(err error) string {
 		return "An unexpected error occurred. Please try again."
 	}
 
-	errStr := err.Error()
-
-	// Check for quota/billing errors
-	if strings.Contains(errStr, "insufficient_quota") ||
-		strings.Contains(errStr, "exceeded your current quota") ||
-		strings.Contains(errStr, "billing") {
+	switch errorClass(err) {
+	case errClassFixtureMissing:
+		// The hint is for whoever runs the server, not for the user
+		logWarn("sanitizeOpenAIError", "No recorded fixture; re-run with LLM_FIXTURE_MODE=record to capture one", slog.Any("error", err))
+	case errClassBudget:
+		return "We've reached today's usage limit. Please try again later."
+	case errClassCircuitOpen, errClassQuota:
 		return "Service temporarily unavailable. Please try again in a few moments."
-	}
-
-	// Check for rate limiting
-	if strings.Contains(errStr, "rate_limit") ||
-		strings.Contains(errStr, "Too Many Requests") {
+	case errClassRateLimit:
 		return "High traffic detected. Please wait a moment and try again."
-	}
-
-	// Check for invalid API key
-	if strings.Contains(errStr, "invalid_api_key") ||
-		strings.Contains(errStr, "Incorrect API key") {
+	case errClassAuth:
 		return "Service configuration error. Please contact support."
-	}
-
-	// Check for timeout
-	if strings.Contains(errStr, "timeout") ||
-		strings.Contains(errStr, "deadline exceeded") {
+	case errClassTimeout:
 		return "Request timed out. Please try again."
 	}
 
@@ -317,13 +283,35 @@ func sanitizeOpenAIError(err error) string {
 	return "Unable to process your request at this time. Please try again later."
 }
 
+func isQuotaError(err error) bool {
+	errStr := err.Error()
+	return strings.Contains(errStr, "insufficient_quota") ||
+		strings.Contains(errStr, "exceeded your current quota") ||
+		strings.Contains(errStr, "billing")
+}
+
+func isRateLimitError(err error) bool {
+	errStr := err.Error()
+	return strings.Contains(errStr, "rate_limit") ||
+		strings.Contains(errStr, "Too Many Requests")
+}
+
 type latencyStats struct {
 	Count   int64   `json:"count"`
 	TotalMs int64   `json:"total_ms"`
 	AvgMs   float64 `json:"avg_ms"`
 
-	path string     `json:"-"`
-	mu   sync.Mutex `json:"-"`
+	// Token usage and cost, summed per job rather than per attempt
+	Jobs    int64    `json:"jobs"`
+	Tokens  LLMUsage `json:"tokens"`
+	CostUSD float64  `json:"cost_usd"`
+
+	// Recent successful jobs, for rolling percentiles (see latency_window.go)
+	Samples []latencySample `json:"samples,omitempty"`
+
+	path   string     `json:"-"`
+	mu     sync.Mutex `json:"-"`
+	saveMu sync.Mutex `json:"-"` // orders saves so an older snapshot never lands last
 }
 
 const AdvisorLatencyPath = "data/chat_latency_stats.json"
@@ -353,21 +341,41 @@ func (ls *latencyStats) load() {
 		ls.Count = tmp.Count
 		ls.TotalMs = tmp.TotalMs
 		ls.AvgMs = tmp.AvgMs
+		ls.Jobs = tmp.Jobs
+		ls.Tokens = tmp.Tokens
+		ls.CostUSD = tmp.CostUSD
+		ls.Samples = tmp.Samples
 	}
 }
 
+// save writes a snapshot of the stats; callers must not hold ls.mu.
 func (ls *latencyStats) save() {
-	tmpPath := ls.path + ".tmp"
-	data, _ := json.MarshalIndent(ls.public(), "", "  ")
-	_ = os.WriteFile(tmpPath, data, 0o644)
-	_ = os.Rename(tmpPath, ls.path)
+	ls.saveMu.Lock()
+	defer ls.saveMu.Unlock()
+
+	ls.mu.Lock()
+	snap := ls.public()
+	ls.mu.Unlock()
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(ls.path, data, 0o644)
+	}
+	if err != nil {
+		logWarn("latencyStats", "Failed to save latency stats", slog.String("path", ls.path), slog.Any("error", err))
+	}
 }
 
+// public copies the persisted fields; callers hold ls.mu.
 func (ls *latencyStats) public() *latencyStats {
 	return &latencyStats{
 		Count:   ls.Count,
 		TotalMs: ls.TotalMs,
 		AvgMs:   ls.AvgMs,
+		Jobs:    ls.Jobs,
+		Tokens:  ls.Tokens,
+		CostUSD: ls.CostUSD,
+		Samples: append([]latencySample(nil), ls.Samples...),
 	}
 }
 
@@ -383,6 +391,41 @@ func (ls *latencyStats) record(d time.Duration) {
 	ls.save()
 }
 
+func (ls *latencyStats) recordUsage(u LLMUsage, cost float64) {
+	ls.mu.Lock()
+	ls.Jobs++
+	ls.Tokens.add(u)
+	ls.CostUSD += cost
+	ls.mu.Unlock()
+
+	ls.save()
+}
+
+// writeFileAtomic replaces path with data through a uniquely named temp file
+// in the same directory, so readers never see a partial file and concurrent
+// writers never share a temp file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
 func getLatencySnapshot(latency *latencyStats) (count int64, totalMs int64, avgMs float64) {
 	latency.mu.Lock()
 	defer latency.mu.Unlock()
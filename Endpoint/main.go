@@ -2,73 +2,127 @@ package main
 
 import (
 	"backend/handlers"
+	"context"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-func main() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/CollegeAdvisor", handlers.Advisor)
-	mux.HandleFunc("/CollegeFetch", handlers.Advisor_Fetch)
-	mux.HandleFunc("/CollegeAdvisorDetails", handlers.SchoolDetails)
-	mux.HandleFunc("/CollegeAdvisorDetailsStatus", handlers.SchoolDetailsStatus)
-
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("ok\n"))
-	})
-
-	// Wrap mux with CORS
-	handler := withCORS(mux)
+// serverCloseGrace bounds how long open connections get once draining is done.
+const serverCloseGrace = 10 * time.Second
 
-	file_location := "/etc/letsencrypt/live/developertesting.xyz/"
+func main() {
+	cfg, err := handlers.LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		// Printed as-is so multi-line validation errors stay readable.
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(2)
+	}
+	cfg.Apply()
+	slog.Info("Configuration", slog.String("config", cfg.Redacted()))
 
-	defaultPort := os.Getenv("PORT")
-	if defaultPort == "" {
-		defaultPort = "443"
+	if err := handlers.OpenJobStore(cfg.Jobs); err != nil {
+		slog.Error("Failed to open job store", slog.Any("error", err))
+		os.Exit(1)
 	}
-	port := flag.String("port", defaultPort, "port to listen on")
-	flag.Parse()
-	addr := ":" + *port
+	handlers.ResumeJobs()
 
-	log.Printf("Serving on https://developertesting.xyz%s", addr)
-	log.Fatal(http.ListenAndServeTLS(addr,
-		file_location+"fullchain.pem",
-		file_location+"privkey.pem",
-		handler))
-}
+	handler := handlers.NewHandler(cfg)
+	srv := handlers.NewServer(cfg, handler)
+	redirect := handlers.NewRedirectServer(cfg)
 
-func withCORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		allowedOrigins := []string{
-			"https://my-aidvisor.webflow.io",
-			"https://www.auroramentor.ai",
+	// Certificates and the OpenAI key reload when their files change or on SIGHUP.
+	reload := time.Duration(cfg.Secrets.ReloadInterval)
+	handlers.WatchSecrets(reload)
+	var certs *handlers.CertManager
+	if cfg.Listen.Mode == handlers.ListenTLS {
+		if certs, err = handlers.NewCertManager(cfg.Listen.TLS.CertFile, cfg.Listen.TLS.KeyFile, reload); err != nil {
+			slog.Error("Failed to load TLS certificate", slog.Any("error", err))
+			os.Exit(1)
 		}
-		origin := r.Header.Get("Origin")
-		for _, o := range allowedOrigins {
-			if o == origin {
-				w.Header().Set("Access-Control-Allow-Origin", origin)
-				// If you need cookies/authorization across origins, enable credentials
-				// w.Header().Set("Access-Control-Allow-Credentials", "true")
-				break
+		srv.TLSConfig = certs.TLSConfig()
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("SIGHUP received, reloading certificates and secrets")
+			handlers.ReloadSecrets("SIGHUP")
+			if certs != nil {
+				_ = certs.Reload("SIGHUP")
 			}
 		}
-		w.Header().Set("Vary", "Origin") // tells caches the response varies by Origin
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		// Ensure common headers are allowed even if the browser doesn't send Access-Control-Request-Headers
-		reqHeaders := r.Header.Get("Access-Control-Request-Headers")
-		allowHeaders := "Content-Type"
-		if reqHeaders != "" {
-			allowHeaders = allowHeaders + ", " + reqHeaders
-		}
-		w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+	}()
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
+	serveErr := make(chan error, 2)
+	if redirect != nil {
+		go func() {
+			slog.Info("Redirecting to HTTPS", slog.String("host", cfg.Listen.Host), slog.String("addr", redirect.Addr))
+			serveErr <- redirect.ListenAndServe()
+		}()
+	}
+	go func() {
+		if certs == nil {
+			slog.Info("Serving", slog.String("scheme", "http"), slog.String("host", cfg.Listen.Host), slog.String("addr", cfg.Listen.Addr))
+			serveErr <- srv.ListenAndServe()
 			return
 		}
+		slog.Info("Serving", slog.String("scheme", "https"), slog.String("host", cfg.Listen.Host), slog.String("addr", cfg.Listen.Addr))
+		serveErr <- srv.ListenAndServeTLS("", "")
+	}()
+
+	stop := make(chan os.Signal, 2)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		slog.Error("Server stopped", slog.Any("error", err))
+		os.Exit(1)
+	case sig := <-stop:
+		slog.Info("Shutting down (send the signal again to exit now)", slog.String("signal", sig.String()))
+	}
+	go func() {
+		<-stop
+		slog.Warn("Second signal received, exiting without draining")
+		os.Exit(1)
+	}()
+
+	shutdown(cfg, srv, redirect)
+}
+
+// shutdown drains running jobs while still serving polls for their results,
+// then closes the listeners and flushes state. Jobs still running at the
+// SHUTDOWN_TIMEOUT deadline resume on the next start.
+func shutdown(cfg *handlers.Config, servers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeouts.Shutdown))
+	defer cancel()
+
+	handlers.DrainJobs(ctx)
+
+	// Give clients a moment to fetch results of jobs that just finished, and
+	// open streams a chance to end, before connections are cut.
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), serverCloseGrace)
+	defer closeCancel()
+	for _, s := range servers {
+		if s == nil {
+			continue
+		}
+		if err := s.Shutdown(closeCtx); err != nil {
+			slog.Warn("Forcing close", slog.String("addr", s.Addr), slog.Any("error", err))
+			_ = s.Close()
+		}
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	handlers.FlushState(flushCtx)
+	slog.Info("Shutdown complete")
 }
@@ -104,9 +104,8 @@ function initFormSubmit() {
       console.log('[AidVisor] recorded payload run', res);
     }
     
-    // For free users, limit to preview schools (backend will handle full list)
-    // We don't restrict school_amount here - backend returns full list
-    // Frontend filters to preview
+    // The server caps school_amount per plan (3 on Free, 10 on Pro), so the
+    // Free list is already limited before the frontend adds locked cards.
 
     await APIController.submitForm(
       payload,
@@ -338,7 +337,7 @@ function initSchoolAmountVisibility() {
     if (input) {
       // Auto-fill with default value if empty
       if (!input.value) {
-        input.value = "3";
+        input.value = String(MembershipController.getVisibleSchoolCount());
       }
       // Remove required attribute since we're auto-filling
       input.removeAttribute('required');
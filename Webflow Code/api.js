@@ -5,8 +5,10 @@
 const APIController = (() => {
   const HOOK_URL = "https://developertesting.xyz:6700/CollegeAdvisor";
   const FETCH_URL = "https://developertesting.xyz:6700/CollegeFetch";
+  const STREAM_URL = "https://developertesting.xyz:6700/CollegeStream";
 
   let pollAbort = null;
+  let eventSource = null;
   let pollTimeout = null;
   let activePollToken = 0;
   let hasFinalResult = false;
@@ -20,10 +22,15 @@ const APIController = (() => {
   }
 
   function abortPolling() {
+    if (eventSource) { eventSource.close(); eventSource = null; }
     if (pollAbort) { pollAbort.abort(); pollAbort = null; }
     if (pollTimeout) { clearTimeout(pollTimeout); pollTimeout = null; }
   }
 
+  function authHeaders() {
+    return (typeof MembershipController !== 'undefined' && MembershipController.authHeaders?.()) || {};
+  }
+
   function tryParseJSON(str) {
     try { return JSON.parse(str); } catch { return null; }
   }
@@ -82,6 +89,7 @@ const APIController = (() => {
     }
 
     while (!signal.aborted && jobId && token === activePollToken && !hasFinalResult) {
+      let delayMs = 2000;
       try {
         const res = await fetch(FETCH_URL, {
           method: "POST",
@@ -91,6 +99,12 @@ const APIController = (() => {
         });
         const data = await res.json().catch(() => null);
 
+        // Throttled: the job is still running, just slow down.
+        if (res.status === 429) {
+          delayMs = Math.max(delayMs, (Number(data?.retry_after) || 5) * 1000);
+          throw new Error("rate limited");
+        }
+
         const x = extractSchoolsFromAny(data);
         if (x?.type === 'schools') return onSchools(x.value);
         if (x?.type === 'invalid') return onError("Invalid fields: " + JSON.stringify(x.value));
@@ -103,10 +117,48 @@ const APIController = (() => {
       if (elapsed >= maxWaitMs) {
         return onError("Processing timed out. Please try again.");
       }
-      await wait(2000, signal);
+      await wait(delayMs, signal);
     }
   }
 
+  // Streams job state over SSE. Falls back to polling if the stream cannot
+  // be opened or drops before a final result arrives.
+  function streamJob(jobId, token, signal, maxWaitMs, avgMs, onSchools, onError) {
+    const es = new EventSource(STREAM_URL + "?id=" + encodeURIComponent(jobId));
+    eventSource = es;
+
+    const timeout = setTimeout(() => {
+      if (token !== activePollToken || hasFinalResult) return;
+      es.close();
+      onError("Processing timed out. Please try again.");
+    }, maxWaitMs);
+
+    const finish = () => {
+      clearTimeout(timeout);
+      es.close();
+      if (eventSource === es) eventSource = null;
+    };
+    signal.addEventListener('abort', finish, { once: true });
+
+    es.addEventListener('done', (e) => {
+      finish();
+      if (token !== activePollToken) return;
+      const x = extractSchoolsFromAny(tryParseJSON(e.data));
+      if (x?.type === 'schools') return onSchools(x.value);
+      if (x?.type === 'invalid') return onError("Invalid fields: " + JSON.stringify(x.value));
+      onError(x?.value || "An error occurred during processing.");
+    });
+
+    // Server-sent "error" events carry data; connection errors do not.
+    es.addEventListener('error', (e) => {
+      finish();
+      if (signal.aborted || token !== activePollToken || hasFinalResult) return;
+      const data = e.data ? tryParseJSON(e.data) : null;
+      if (data?.error) return onError(data.error);
+      pollLoop(jobId, token, signal, maxWaitMs, avgMs, onSchools, onError);
+    });
+  }
+
   async function startPolling(jobId, avgMs, samples, onSchools, onError, onProgress) {
     currentJobId = jobId;
     hasFinalResult = false;
@@ -119,11 +171,16 @@ const APIController = (() => {
     const myToken = ++activePollToken;
 
     const MAX_WAIT_MS = 6 * 60 * 1000;
-    
+
+    if (typeof EventSource === "function") {
+      return streamJob(jobId, myToken, signal, MAX_WAIT_MS, avgMs, onSchools, onError);
+    }
     pollLoop(jobId, myToken, signal, MAX_WAIT_MS, avgMs, onSchools, onError);
   }
 
   async function submitForm(payload, onSchools, onError, onProgress) {
+    // A resubmission while a job is still running replaces it server-side.
+    const supersedes = (!hasFinalResult && currentJobId) ? currentJobId : null;
     abortPolling();
     hasFinalResult = false;
     currentJobId = null;
@@ -131,11 +188,17 @@ const APIController = (() => {
     try {
       const res = await fetch(HOOK_URL, {
         method: "POST",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify(payload)
+        headers: { "Content-Type": "application/json", ...authHeaders() },
+        body: JSON.stringify(supersedes ? { ...payload, supersedes } : payload)
       });
       const data = await res.json().catch(() => null);
 
+      // Server-enforced plan limit: show the upgrade prompt, not a raw error.
+      if (data?.code === 'plan_limit' && typeof MembershipController !== 'undefined') {
+        MembershipController.showUpgradeModal('unlimited runs and refinements');
+        return onError(data.error);
+      }
+
       const direct = extractSchoolsFromAny(data);
       if (direct?.type === 'schools') return onSchools(direct.value);
       if (direct?.type === 'invalid') return onError("Invalid fields: " + JSON.stringify(direct.value));
@@ -143,7 +206,9 @@ const APIController = (() => {
 
       const id = ["id", "job_id", "request_id"].map(k => data?.[k]).find(Boolean);
       if (id) {
-        return startPolling(id, data?.avg_chatgpt_ms, data?.samples, onSchools, onError, onProgress);
+        // estimate_ms covers this school_amount and the queue ahead; older servers only send the average
+        const etaMs = data?.estimate_ms ?? data?.avg_chatgpt_ms;
+        return startPolling(id, etaMs, data?.latency?.samples ?? data?.samples, onSchools, onError, onProgress);
       }
 
       if (res.ok) {
@@ -88,12 +88,13 @@ const DetailsController = (() => {
       profile = null;
     }
 
+    const auth = (typeof MembershipController !== 'undefined' && MembershipController.authHeaders?.()) || {};
     fetch(SCHOOL_DETAILS_ENDPOINT, {
       method: "POST",
-      headers: { "Content-Type": "application/json" },
+      headers: { "Content-Type": "application/json", ...auth },
       body: JSON.stringify({ school: schoolName, profile })
     })
-      .then(r => r.ok ? r.json() : Promise.reject(new Error(`HTTP ${r.status}`)))
+      .then(readDetailsResponse)
       .then(data => {
         if (!panel.isConnected) return;
 
@@ -103,19 +104,54 @@ const DetailsController = (() => {
 
         if (!data || !data.id) throw new Error("Malformed response");
 
+        // Prefer the server's estimate for this school (recent p50 + queue wait)
+        const etaMs = data.estimate_ms ?? data.avg_chatgpt_ms;
         startProgressPollInline(panel, schoolName, data.id, {
-          avgMs: (typeof data.avg_chatgpt_ms === "number" && isFinite(data.avg_chatgpt_ms) && data.avg_chatgpt_ms > 0)
-            ? data.avg_chatgpt_ms
+          avgMs: (typeof etaMs === "number" && isFinite(etaMs) && etaMs > 0)
+            ? etaMs
             : 20000,
-          samples: Number(data.samples) || 0
+          samples: Number(data.latency?.samples ?? data.samples) || 0
         });
       })
       .catch(err => {
         if (!panel.isConnected) return;
+        if (err?.code === 'plan_limit' || err?.code === 'pro_required') {
+          return showPlanLimitInline(panel, schoolName);
+        }
         showErrorInline(panel, err);
       });
   }
 
+  // Turns an error status into an Error carrying the server's message and
+  // code, so plan limits and expired sessions don't surface as "HTTP 403".
+  function readDetailsResponse(r) {
+    if (r.ok) return r.json();
+    return r.json().catch(() => ({})).then(data => {
+      let message = data?.error;
+      if (r.status === 401) {
+        message = message || "Please log in to see school details.";
+      } else if (r.status === 429) {
+        const wait = Number(data?.retry_after) || 5;
+        message = `Too many requests. Please try again in ${wait}s.`;
+      }
+      const err = new Error(message || `HTTP ${r.status}`);
+      err.code = data?.code;
+      throw err;
+    });
+  }
+
+  function showPlanLimitInline(container, schoolName) {
+    if (!container?.isConnected) return;
+    if (typeof MembershipController === 'undefined') {
+      return showErrorInline(container, new Error("School details are a Pro feature."));
+    }
+    container.innerHTML = `
+      <div class="ai-card">
+        ${MembershipController.showDetailTeaser(schoolName)}
+      </div>`;
+    MembershipController.showUpgradeModal?.('see full school names and details');
+  }
+
   function formatETA(ms) {
     ms = Math.max(0, Math.floor(ms));
     if (ms < 1000) return "under 1s remaining";
@@ -185,11 +221,19 @@ const DetailsController = (() => {
       updateEta(elapsed);
 
       fetch(`${SCHOOL_DETAILS_STATUS}?id=${encodeURIComponent(id)}`)
-        .then(r => (r.ok ? r.json() : Promise.reject(new Error(`HTTP ${r.status}`))))
+        .then(r => {
+          // Throttled: keep polling after the server's suggested wait.
+          if (r.status === 429) {
+            return r.json().catch(() => ({})).then(d => ({ status: "processing", retryAfter: Number(d?.retry_after) || 5 }));
+          }
+          return r.ok ? r.json() : Promise.reject(new Error(`HTTP ${r.status}`));
+        })
         .then(status => {
           if (cancelled || !container.isConnected) return;
 
-          if (status.status === "processing") {
+          if (status.retryAfter) {
+            setTimeout(tick, status.retryAfter * 1000);
+          } else if (status.status === "processing") {
             pollDelay = Math.min(1500, pollDelay + 100);
             setTimeout(tick, pollDelay);
           } else if (status.status === "done" && status.data) {
@@ -5,11 +5,12 @@
 
 const MembershipController = (() => {
   // Memberstack integration
-  const MAX_FREE_PREVIEW_SCHOOLS = 3; // Fully visible schools for free users
-  const MAX_FREE_BLURRED_SCHOOLS = 6; // Additional blurred previews for free users
-  const MAX_FREE_SCHOOLS = MAX_FREE_PREVIEW_SCHOOLS + MAX_FREE_BLURRED_SCHOOLS;
-  const MAX_PRO_SCHOOLS = 30; // Full list for pro users
-  const MAX_FREE_RERUNS = 2; // Free users get two unique payload runs
+  // The server enforces plan limits (Endpoint/handlers/plans.go); these mirror
+  // its defaults so the UI describes what the server actually returns.
+  const MAX_FREE_SCHOOLS = 3; // FREE_MAX_SCHOOLS: named schools returned to free users
+  const MAX_FREE_LOCKED_CARDS = 6; // Locked placeholder cards shown after them
+  const MAX_PRO_SCHOOLS = 10; // PRO_MAX_SCHOOLS
+  const MAX_FREE_RERUNS = 2; // FREE_MAX_RUNS: unique payload runs for free users
   const FREE_BANNER_ID = 'ai-free-plan-banner';
   const BASE_STYLE_ID = 'ai-membership-base-styles';
   const RERUN_COUNT_KEY = 'ai_rerun_count';
@@ -319,6 +320,23 @@ const MembershipController = (() => {
     }
   }
 
+  // Member JWT for the backend, which verifies the plan itself.
+  // Memberstack 2.0 exposes it as the member cookie.
+  function getMemberToken() {
+    try {
+      const token = window.$memberstackDom?.getMemberCookie?.();
+      return typeof token === 'string' && token ? token : null;
+    } catch (e) {
+      return null;
+    }
+  }
+
+  // Headers to add to backend requests so limits follow the member, not the browser.
+  function authHeaders() {
+    const token = getMemberToken();
+    return token ? { Authorization: 'Bearer ' + token } : {};
+  }
+
   // Check if user has pro plan
   function isPro() {
     return userPlan === 'pro';
@@ -344,7 +362,7 @@ const MembershipController = (() => {
     banner.className = 'ai-free-banner';
     banner.innerHTML = `
       <div class="ai-free-banner__label">Free Plan</div>
-      <div class="ai-free-banner__text">You're on the Free plan with 3 named schools plus 6 blurred previews. Upgrade to Pro to unlock every match and full details.</div>
+      <div class="ai-free-banner__text">You're on the Free plan with your top 3 school matches. Upgrade to Pro for up to 10 matches and full school details.</div>
       <button class="ai-banner-upgrade-btn" id="ai-banner-upgrade">Upgrade to Pro</button>
     `;
 
@@ -439,7 +457,7 @@ const MembershipController = (() => {
     }
     
     // Free users: 3 named schools
-    const visible = schools.slice(0, MAX_FREE_PREVIEW_SCHOOLS).map((school) => ({
+    const visible = schools.slice(0, MAX_FREE_SCHOOLS).map((school) => ({
       ...school,
       isPreview: true,
       hiddenDetails: true,
@@ -447,10 +465,12 @@ const MembershipController = (() => {
       isFake: false
     }));
 
-    // Free users: Always show 6 blurred cards (use actual data if available, or create placeholder fakes)
+    // Free users: locked cards after the named schools. The server returns only
+    // MAX_FREE_SCHOOLS schools on Free, so these are placeholders unless that
+    // limit is raised server-side.
     const blurredFakes = [];
-    for (let i = 0; i < MAX_FREE_BLURRED_SCHOOLS; i++) {
-      const sourceSchool = schools[MAX_FREE_PREVIEW_SCHOOLS + i];
+    for (let i = 0; i < MAX_FREE_LOCKED_CARDS; i++) {
+      const sourceSchool = schools[MAX_FREE_SCHOOLS + i];
       if (sourceSchool) {
         blurredFakes.push({
           ...sourceSchool,
@@ -487,7 +507,7 @@ const MembershipController = (() => {
     if (!Array.isArray(schools)) return [];
     if (isPro()) return schools.slice();
 
-    const visible = schools.slice(0, MAX_FREE_PREVIEW_SCHOOLS).map((school) => ({
+    const visible = schools.slice(0, MAX_FREE_SCHOOLS).map((school) => ({
       ...school,
       isPreview: true,
       hiddenDetails: true,
@@ -495,7 +515,7 @@ const MembershipController = (() => {
       isFake: false
     }));
 
-    const blurredSource = schools.slice(MAX_FREE_PREVIEW_SCHOOLS, MAX_FREE_PREVIEW_SCHOOLS + MAX_FREE_BLURRED_SCHOOLS);
+    const blurredSource = schools.slice(MAX_FREE_SCHOOLS, MAX_FREE_SCHOOLS + MAX_FREE_LOCKED_CARDS);
     const blurred = blurredSource.map((school, i) => ({
       ...school,
       name: maskSchoolName(school.name || `Hidden Match ${i + 1}`),
@@ -762,9 +782,9 @@ const MembershipController = (() => {
         <div class="ai-upgrade-prompt-icon">🔒</div>
         <div class="ai-upgrade-prompt-content">
           <h3>Reveal Every School</h3>
-          <p>You're seeing 3 named schools plus 6 blurred previews. Upgrade to Pro to unlock the names and ${remainingCount > 0 ? `${remainingCount} more matches` : 'your full personalized list'}.</p>
+          <p>You're seeing your top 3 matches. Upgrade to Pro to unlock ${remainingCount > 0 ? `${remainingCount} more matches` : 'up to 10 matches per run'} and full details for every school.</p>
           <ul class="ai-upgrade-features">
-            <li>Full school list (15-30 matches)</li>
+            <li>Up to 10 matches per run</li>
             <li>Reach / Target / Safety labels</li>
             <li>Acceptance likelihood for each school</li>
             <li>Financial fit analysis & net cost</li>
@@ -877,6 +897,7 @@ const MembershipController = (() => {
     detectMembershipTier,
     isPro,
     isFree,
+    authHeaders,
     getVisibleSchoolCount,
     filterSchoolsForDisplay,
     renderSchoolCard,